@@ -0,0 +1,67 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Amount is a quantity of coins expressed in integer base units, so sums and
+// signed payloads never depend on floating point rounding or formatting.
+type Amount int64
+
+const (
+	AMOUNT_DECIMALS = 8
+	COIN            = Amount(100000000)
+)
+
+var ErrInvalidAmount = errors.New("invalid amount")
+
+// ParseAmount reads a decimal string such as "12.5" into base units. It
+// rejects negative values and more than AMOUNT_DECIMALS fractional digits.
+func ParseAmount(s string) (Amount, error) {
+	s = strings.TrimSpace(s)
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" && frac == "" || strings.HasPrefix(whole, "-") || strings.HasPrefix(whole, "+") {
+		return 0, ErrInvalidAmount
+	}
+	if len(frac) > AMOUNT_DECIMALS {
+		return 0, fmt.Errorf("%w: more than %d decimals", ErrInvalidAmount, AMOUNT_DECIMALS)
+	}
+
+	var coins, units uint64
+	var err error
+	if whole != "" {
+		if coins, err = strconv.ParseUint(whole, 10, 63); err != nil {
+			return 0, ErrInvalidAmount
+		}
+	}
+	if frac != "" {
+		frac += strings.Repeat("0", AMOUNT_DECIMALS-len(frac))
+		if units, err = strconv.ParseUint(frac, 10, 63); err != nil {
+			return 0, ErrInvalidAmount
+		}
+	}
+
+	if coins > uint64((1<<63-1-int64(units))/int64(COIN)) {
+		return 0, fmt.Errorf("%w: out of range", ErrInvalidAmount)
+	}
+	return Amount(coins)*COIN + Amount(units), nil
+}
+
+// String formats the amount as a decimal number of coins without trailing
+// fractional zeros.
+func (a Amount) String() string {
+	sign := ""
+	if a < 0 {
+		sign = "-"
+		a = -a
+	}
+
+	s := fmt.Sprintf("%s%d", sign, a/COIN)
+	if frac := a % COIN; frac != 0 {
+		s += "." + strings.TrimRight(fmt.Sprintf("%0*d", AMOUNT_DECIMALS, frac), "0")
+	}
+	return s
+}
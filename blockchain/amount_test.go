@@ -0,0 +1,51 @@
+package blockchain
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseAmountRoundTrip(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Amount
+		out  string
+	}{
+		{"0", 0, "0"},
+		{"1", COIN, "1"},
+		{"12.5", 12*COIN + COIN/2, "12.5"},
+		{"0.00000001", 1, "0.00000001"},
+		{".25", COIN / 4, "0.25"},
+		{"3.10", 3*COIN + COIN/10, "3.1"},
+		{" 7 ", 7 * COIN, "7"},
+		{"92233720368.54775807", 1<<63 - 1, "92233720368.54775807"},
+	}
+	for _, tt := range tests {
+		got, err := ParseAmount(tt.in)
+		if err != nil {
+			t.Errorf("ParseAmount(%q): %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseAmount(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+		if s := got.String(); s != tt.out {
+			t.Errorf("Amount(%d).String() = %q, want %q", got, s, tt.out)
+		}
+	}
+
+	if s := (-COIN / 2).String(); s != "-0.5" {
+		t.Errorf("negative amount formats as %q", s)
+	}
+}
+
+func TestParseAmountRejectsBadInput(t *testing.T) {
+	for _, in := range []string{
+		"", ".", "-1", "+1", "1.000000001", "abc", "1.2.3", "1e3", "0x10",
+		"92233720368.54775808", "100000000000",
+	} {
+		if _, err := ParseAmount(in); !errors.Is(err, ErrInvalidAmount) {
+			t.Errorf("ParseAmount(%q) = %v, want ErrInvalidAmount", in, err)
+		}
+	}
+}
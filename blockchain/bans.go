@@ -0,0 +1,164 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"time"
+)
+
+const (
+	// A peer whose misbehaviour score reaches BAN_SCORE is refused for
+	// BAN_DURATION.
+	BAN_SCORE    = 100
+	BAN_DURATION = 24 * time.Hour
+
+	PENALTY_INVALID_BLOCK       = 100
+	PENALTY_INVALID_TRANSACTION = 20
+	PENALTY_MALFORMED           = 20
+	PENALTY_TIMEOUT             = 5
+)
+
+// Penalty rates what an error caused by data a peer sent says about that
+// peer. Transport failures other than timeouts, and transactions that are
+// merely stale or conflicting, cost nothing.
+func Penalty(err error) int {
+	var netErr net.Error
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return PENALTY_TIMEOUT
+	case errors.As(err, &syntaxErr),
+		errors.As(err, &typeErr),
+		errors.Is(err, ErrMalformedEncoding),
+		errors.Is(err, ErrMalformedMessage):
+		return PENALTY_MALFORMED
+	case errors.Is(err, ErrInvalidBlock),
+		errors.Is(err, ErrInvalidHeader),
+		errors.Is(err, ErrInvalidCoinbase),
+		errors.Is(err, ErrInvalidStateRoot),
+		errors.Is(err, ErrBlockTooLarge):
+		return PENALTY_INVALID_BLOCK
+	case errors.Is(err, ErrInvalidSignature),
+		errors.Is(err, ErrSenderMismatch),
+		errors.Is(err, ErrInvalidValue),
+		errors.Is(err, ErrInsufficientBalance),
+		errors.Is(err, ErrInvalidAddress):
+		return PENALTY_INVALID_TRANSACTION
+	}
+	return 0
+}
+
+// Misbehaving charges peer for err and drops it once that gets it banned.
+func (bc *Blockchain) Misbehaving(peer string, err error) {
+	penalty := Penalty(err)
+	if peer == "" || penalty == 0 {
+		return
+	}
+
+	log.Printf("Peer %s misbehaved (+%d): %v", peer, penalty, err)
+	if bc.peers.Misbehaved(peer, penalty) {
+		log.Printf("Banned peer %s for %s", peer, BAN_DURATION)
+		bc.disconnect(peer)
+	}
+}
+
+// Ban refuses address for d, whatever its score.
+func (bc *Blockchain) Ban(address string, d time.Duration) bool {
+	if !bc.peers.Ban(address, time.Now().Add(d)) {
+		return false
+	}
+	log.Printf("Banned peer %s for %s", address, d)
+	bc.disconnect(address)
+	return true
+}
+
+// Unban lets address connect again, reporting whether it was banned.
+func (bc *Blockchain) Unban(address string) bool {
+	if !bc.peers.Unban(address) {
+		return false
+	}
+	log.Printf("Unbanned peer %s", address)
+	bc.savePeers()
+	return true
+}
+
+func (bc *Blockchain) Banned(address string) bool {
+	return address != "" && bc.peers.Banned(address)
+}
+
+// BannedRemote reports whether a connection or request from the remote
+// address comes from the host of a banned peer. Inbound peers are checked
+// this way rather than by the address they claim.
+func (bc *Blockchain) BannedRemote(remote string) bool {
+	host, _, err := net.SplitHostPort(remote)
+	return err == nil && bc.peers.BannedHost(host)
+}
+
+// InboundPeer returns address, which a peer sending from remote claims as its
+// own, if it is at that host, and "" otherwise. Misbehaviour is only charged
+// to an address the peer cannot pick freely.
+func (bc *Blockchain) InboundPeer(address string, remote string) string {
+	if address == "" || !sameHost(address, remote) {
+		return ""
+	}
+	return address
+}
+
+// sameHost reports whether the host of address, which a peer claims as its
+// own, is the IP of the remote address it connected from.
+func sameHost(address string, remote string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	remoteHost, _, err := net.SplitHostPort(remote)
+	if err != nil {
+		return false
+	}
+	remoteIP := net.ParseIP(remoteHost)
+	if remoteIP == nil {
+		return false
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.Equal(remoteIP)
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return false
+	}
+	for _, ip := range ips {
+		if ip.Equal(remoteIP) {
+			return true
+		}
+	}
+	return false
+}
+
+func (bc *Blockchain) Bans() []*PeerInfo {
+	return bc.peers.Bans()
+}
+
+// disconnect stops talking to a banned peer and forgets its handshake.
+func (bc *Blockchain) disconnect(peer string) {
+	bc.muxNeighbors.Lock()
+	neighbors := bc.neighbors[:0:0]
+	for _, n := range bc.neighbors {
+		if n != peer {
+			neighbors = append(neighbors, n)
+		}
+	}
+	bc.neighbors = neighbors
+	delete(bc.versions, peer)
+	bc.muxNeighbors.Unlock()
+
+	bc.closeConns(peer)
+
+	bc.savePeers()
+}
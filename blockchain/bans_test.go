@@ -0,0 +1,70 @@
+package blockchain
+
+import (
+	"errors"
+	"io"
+	"testing"
+)
+
+func peerVersion(from string) *VersionMessage {
+	return &VersionMessage{Version: PROTOCOL_VERSION, Network: DEFAULT_NETWORK, From: from, Services: REQUIRED_SERVICES}
+}
+
+func TestPenalty(t *testing.T) {
+	for _, c := range []struct {
+		err  error
+		want int
+	}{
+		{nil, 0},
+		{&BlockError{Block: &Block{}, Reason: ErrInvalidStateRoot}, PENALTY_INVALID_BLOCK},
+		{rejectTransaction(&Transaction{}, ErrInvalidSignature, "input"), PENALTY_INVALID_TRANSACTION},
+		{rejectTransaction(&Transaction{}, ErrMissingInput, "input"), 0},
+		// A connection cut mid-message says nothing about the peer.
+		{io.ErrUnexpectedEOF, 0},
+	} {
+		if got := Penalty(c.err); got != c.want {
+			t.Errorf("Penalty(%v) = %d, want %d", c.err, got, c.want)
+		}
+	}
+}
+
+func TestReceiveVersionRefusesClaimedAddress(t *testing.T) {
+	bc := newTestChain(t, t.TempDir())
+
+	// A host may not pass itself off as another to spend that peer's score.
+	if _, err := bc.ReceiveVersion(peerVersion("10.0.0.2:5000"), "10.0.0.1:41000"); !errors.Is(err, ErrIncompatiblePeer) {
+		t.Fatalf("handshake claiming another host accepted with error %v", err)
+	}
+	if bc.handshaken("10.0.0.2:5000") {
+		t.Error("claimed address was recorded")
+	}
+
+	if _, err := bc.ReceiveVersion(peerVersion("10.0.0.1:5000"), "10.0.0.1:41000"); err != nil {
+		t.Fatalf("handshake from its own host refused: %v", err)
+	}
+}
+
+func TestBanAppliesToHost(t *testing.T) {
+	bc := newTestChain(t, t.TempDir())
+	bc.Misbehaving("10.0.0.1:5000", &BlockError{Block: &Block{}, Reason: ErrInvalidStateRoot})
+	if !bc.Banned("10.0.0.1:5000") {
+		t.Fatal("peer not banned for an invalid block")
+	}
+
+	// Coming back under another port does not get around the ban.
+	if _, err := bc.ReceiveVersion(peerVersion("10.0.0.1:5001"), "10.0.0.1:41000"); !errors.Is(err, ErrBannedPeer) {
+		t.Errorf("banned host shook hands with error %v", err)
+	}
+	if _, err := bc.ReceiveVersion(peerVersion("10.0.0.2:5000"), "10.0.0.2:41000"); err != nil {
+		t.Errorf("other host refused: %v", err)
+	}
+
+	// Nodes sharing a machine are banned one address at a time.
+	bc.Misbehaving("127.0.0.1:5000", &BlockError{Block: &Block{}, Reason: ErrInvalidStateRoot})
+	if _, err := bc.ReceiveVersion(peerVersion("127.0.0.1:5000"), "127.0.0.1:41000"); !errors.Is(err, ErrBannedPeer) {
+		t.Errorf("banned loopback peer shook hands with error %v", err)
+	}
+	if _, err := bc.ReceiveVersion(peerVersion("127.0.0.1:5001"), "127.0.0.1:41001"); err != nil {
+		t.Errorf("other loopback peer refused: %v", err)
+	}
+}
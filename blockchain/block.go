@@ -8,31 +8,120 @@ import (
 	"time"
 )
 
-type Block struct {
-	TimeStamp    int64
-	Nonce        int
+const (
+	BLOCK_VERSION     = 1
+	BLOCK_HEADER_SIZE = 4 + 8 + 32 + 32 + 32 + 8 + 4 + 8
+)
+
+// BlockHeader is the fixed-size part of a block that proof-of-work hashes. The
+// transactions are committed to through MerkleRoot, and the unspent outputs
+// left once they are applied through StateRoot.
+type BlockHeader struct {
+	Version      uint32
+	Height       uint64
 	PreviousHash [32]byte
+	MerkleRoot   [32]byte
+	StateRoot    [32]byte
+	TimeStamp    int64
+	Bits         uint32
+	Nonce        uint64
+}
+
+func (h *BlockHeader) Hash() [32]byte {
+	return sha256.Sum256(h.Bytes())
+}
+
+// CheckHeader validates header on top of previous without looking at any
+// transactions: linkage, height, timestamp, the expected target bits and the
+// proof-of-work.
+func CheckHeader(header *BlockHeader, previous *BlockHeader, bits uint32) error {
+	switch {
+	case header.Version != BLOCK_VERSION:
+		return fmt.Errorf("%w: unknown version %d", ErrInvalidHeader, header.Version)
+	case header.Height != previous.Height+1:
+		return fmt.Errorf("%w: height %d after %d", ErrInvalidHeader, header.Height, previous.Height)
+	case header.PreviousHash != previous.Hash():
+		return fmt.Errorf("%w: previous hash does not match block %d", ErrInvalidHeader, previous.Height)
+	case header.TimeStamp <= previous.TimeStamp || header.TimeStamp > time.Now().Add(MAX_FUTURE_BLOCK).UnixNano():
+		return fmt.Errorf("%w: timestamp out of range", ErrInvalidHeader)
+	case header.Bits != bits:
+		return fmt.Errorf("%w: target %08x, expected %08x", ErrInvalidHeader, header.Bits, bits)
+	case !HashMeetsTarget(header.Hash(), header.Bits):
+		return fmt.Errorf("%w: hash does not meet target", ErrInvalidHeader)
+	}
+	return nil
+}
+
+type Block struct {
+	BlockHeader
 	Transactions []*Transaction
 }
 
 func (b *Block) Hash() [32]byte {
-	m, _ := b.MarshalJson()
-	return sha256.Sum256([]byte(m))
+	return b.BlockHeader.Hash()
+}
+
+// WellFormed reports whether a decoded block is free of null transactions,
+// inputs and outputs, which JSON lets a peer send but nothing past decoding
+// expects.
+func (b *Block) WellFormed() bool {
+	for _, t := range b.Transactions {
+		if t == nil {
+			return false
+		}
+		for _, in := range t.Inputs {
+			if in == nil {
+				return false
+			}
+		}
+		for _, out := range t.Outputs {
+			if out == nil {
+				return false
+			}
+		}
+	}
+	return true
 }
 
-func NewBlock(nonce int, previousHash [32]byte, transactions []*Transaction) *Block {
+// BlockSize is the size of transactions as counted against MAX_BLOCK_SIZE.
+func BlockSize(transactions []*Transaction) int {
+	size := 0
+	for _, t := range transactions {
+		size += t.Size()
+	}
+	return size
+}
+
+func NewBlock(height uint64, bits uint32, previousHash [32]byte, transactions []*Transaction) *Block {
 	b := new(Block)
+	b.Version = BLOCK_VERSION
+	b.Height = height
 	b.TimeStamp = time.Now().UnixNano()
 	b.PreviousHash = previousHash
-	b.Nonce = nonce
+	b.MerkleRoot = TransactionsMerkleRoot(transactions)
+	b.Bits = bits
 	b.Transactions = transactions
 	return b
 }
 
+// GenesisBlock is the first block of every chain. It is fixed so that nodes
+// started separately share it and can find where their chains fork.
+func GenesisBlock() *Block {
+	b := &Block{Transactions: []*Transaction{}}
+	b.Version = BLOCK_VERSION
+	b.Bits = INITIAL_BITS
+	b.MerkleRoot = TransactionsMerkleRoot(b.Transactions)
+	return b
+}
+
 func (b *Block) Print() {
+	fmt.Printf("height:\t\t%d\n", b.Height)
 	fmt.Printf("timestamp:\t%d\n", b.TimeStamp)
 	fmt.Printf("nonce:\t\t%d\n", b.Nonce)
+	fmt.Printf("bits:\t\t%08x\n", b.Bits)
 	fmt.Printf("previous_hash:\t%x\n", b.PreviousHash)
+	fmt.Printf("merkle_root:\t%x\n", b.MerkleRoot)
+	fmt.Printf("state_root:\t%x\n", b.StateRoot)
 	for _, t := range b.Transactions {
 		t.Print()
 	}
@@ -40,13 +129,23 @@ func (b *Block) Print() {
 
 func (b *Block) MarshalJson() ([]byte, error) {
 	return json.Marshal(struct {
-		Nonce        int            `json:"nonce"`
+		Version      uint32         `json:"version"`
+		Height       uint64         `json:"height"`
+		Nonce        uint64         `json:"nonce"`
+		Bits         uint32         `json:"bits"`
 		PreviousHash string         `json:"previous_hash"`
+		MerkleRoot   string         `json:"merkle_root"`
+		StateRoot    string         `json:"state_root"`
 		TimeStamp    int64          `json:"time_stamp"`
 		Transactions []*Transaction `json:"transactions"`
 	}{
+		Version:      b.Version,
+		Height:       b.Height,
 		Nonce:        b.Nonce,
+		Bits:         b.Bits,
 		PreviousHash: fmt.Sprintf("%x", b.PreviousHash),
+		MerkleRoot:   fmt.Sprintf("%x", b.MerkleRoot),
+		StateRoot:    fmt.Sprintf("%x", b.StateRoot),
 		TimeStamp:    b.TimeStamp,
 		Transactions: b.Transactions,
 	})
@@ -54,15 +153,27 @@ func (b *Block) MarshalJson() ([]byte, error) {
 
 func (b *Block) UnmarshalJson(data []byte) error {
 	var previousHash string
+	var merkleRoot string
+	var stateRoot string
 
 	v := struct {
-		Nonce        *int            `json:"nonce"`
+		Version      *uint32         `json:"version"`
+		Height       *uint64         `json:"height"`
+		Nonce        *uint64         `json:"nonce"`
+		Bits         *uint32         `json:"bits"`
 		PreviousHash *string         `json:"previous_hash"`
+		MerkleRoot   *string         `json:"merkle_root"`
+		StateRoot    *string         `json:"state_root"`
 		TimeStamp    *int64          `json:"time_stamp"`
 		Transactions *[]*Transaction `json:"transactions"`
 	}{
+		Version:      &b.Version,
+		Height:       &b.Height,
 		Nonce:        &b.Nonce,
+		Bits:         &b.Bits,
 		PreviousHash: &previousHash,
+		MerkleRoot:   &merkleRoot,
+		StateRoot:    &stateRoot,
 		TimeStamp:    &b.TimeStamp,
 		Transactions: &b.Transactions,
 	}
@@ -72,6 +183,10 @@ func (b *Block) UnmarshalJson(data []byte) error {
 	}
 
 	ph, _ := hex.DecodeString(*v.PreviousHash)
-	copy(b.PreviousHash[:], ph[:32])
+	copy(b.PreviousHash[:], ph)
+	mr, _ := hex.DecodeString(*v.MerkleRoot)
+	copy(b.MerkleRoot[:], mr)
+	sr, _ := hex.DecodeString(*v.StateRoot)
+	copy(b.StateRoot[:], sr)
 	return nil
 }
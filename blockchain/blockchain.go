@@ -1,97 +1,144 @@
 package blockchain
 
 import (
-	"bytes"
-	"crypto/ecdsa"
-	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"log"
-	"net/http"
+	"math"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/jvsena42/go_blockchain/utils"
 )
 
 const (
-	MINING_DIFICULTY = 3
-	MINING_SENDER    = "BLOCKCHAIN REWARD SYSTEM"
-	MINING_REWARD    = 1.0
-	MINING_TIMER_SEC = 20
-
-	BLOCKCHAIN_PORT_RANGE_START       = 3333
-	BLOCKCHAIN_PORT_RANGE_END         = 3336
-	NEIGHBOR_IP_RANGE_START           = 0
-	NEIGHBOR_IP_RANGE_END             = 3
+	MINING_REWARD = 1 * COIN
+
+	// Transactions in a block may take up at most MAX_BLOCK_SIZE bytes, and
+	// are relayed only if they pay MIN_RELAY_FEE_RATE base units per byte.
+	MAX_BLOCK_SIZE     = 100000
+	MIN_RELAY_FEE_RATE = Amount(10)
+
+	MAX_HEADERS_PER_REQUEST = 2000
+
 	BLOCKCHAIN_NEIGBHOR_SYNC_TIME_SEC = 10
 )
 
 type Blockchain struct {
-	TransactionPool   []*Transaction
-	Chain             []*Block
-	BlockChainAddress string
-	Port              uint16
-	mux               sync.Mutex
+	Chain []*Block
+	Port  uint16
+	mux   sync.Mutex
 
 	neighbors    []string
 	muxNeighbors sync.Mutex
+	peers        *PeerTable
+	versions     map[string]*VersionMessage
+	network      string
+	syncing      atomic.Bool
+
+	conns    map[*PeerConn]bool
+	muxConns sync.Mutex
+	listen   string
+
+	store   *Store
+	utxos   *UTXOSet
+	mempool *Mempool
+	miner   *Miner
 }
 
-func (bc *Blockchain) CalculateTotalAmount(blockchainAddress string) float32 {
-	var totalAmount float32 = 0
-	for _, b := range bc.Chain {
-		for _, t := range b.Transactions {
-			value := t.Value
-			if blockchainAddress == t.RecipientAddress {
-				totalAmount += value
-			}
+// Amount returns the balance of blockchainAddress along with the tip it was
+// computed at.
+func (bc *Blockchain) Amount(blockchainAddress string) *AmountResponse {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
 
-			if blockchainAddress == t.SenderAddress {
-				totalAmount -= value
-			}
-		}
+	last := bc.LastBlock()
+	return &AmountResponse{
+		Amount:    bc.CalculateTotalAmount(blockchainAddress),
+		Height:    last.Height,
+		BlockHash: last.Hash(),
 	}
-	return totalAmount
 }
 
-func NewBlockchain(blockChainAddress string, port uint16) *Blockchain {
-	b := &Block{}
+// CalculateTotalAmount sums the unspent outputs paying blockchainAddress.
+func (bc *Blockchain) CalculateTotalAmount(blockchainAddress string) Amount {
+	return bc.utxos.Balance(blockchainAddress)
+}
+
+// SpendableOutputs lists the outputs blockchainAddress can spend in its next
+// transaction: its unspent outputs, including those created by pending
+// transactions, that no pending transaction spends yet.
+func (bc *Blockchain) SpendableOutputs(blockchainAddress string) []*UTXO {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	return bc.mempool.Spendable(blockchainAddress, bc.utxos)
+}
+
+// NewBlockchain reopens the chain and transaction pool stored in dataDir,
+// creating the genesis block when the directory holds no chain yet.
+func NewBlockchain(port uint16, dataDir string) (*Blockchain, error) {
+	store, err := OpenStore(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
 	bc := new(Blockchain)
-	bc.CreateBlock(0, b.Hash())
-	bc.BlockChainAddress = blockChainAddress
 	bc.Port = port
-	return bc
-}
+	bc.store = store
+	bc.utxos = NewUTXOSet()
+	bc.mempool = NewMempool(MEMPOOL_MAX_SIZE, MEMPOOL_EXPIRY)
+	bc.miner = NewMiner(bc, 0)
+
+	bc.Chain, err = store.Blocks()
+	if err != nil {
+		return nil, err
+	}
 
-func (bc *Blockchain) Run() {
-	bc.StartSyncNeighbors()
-	bc.ResolveConflicts()
-	bc.StartMining()
-}
-
-func (bc *Blockchain) SetNeightbors() {
-	bc.neighbors = utils.FindNeighbors(
-		utils.GetHost(),
-		bc.Port,
-		NEIGHBOR_IP_RANGE_START,
-		NEIGHBOR_IP_RANGE_END,
-		BLOCKCHAIN_PORT_RANGE_START,
-		BLOCKCHAIN_PORT_RANGE_END,
-	)
-
-	if len(bc.neighbors) > 0 {
-		log.Println("This node's neighbors are", bc.neighbors)
+	pool, err := store.LoadPool()
+	if err != nil {
+		return nil, err
+	}
+
+	peers, err := store.LoadPeers()
+	if err != nil {
+		return nil, err
+	}
+	bc.peers = NewPeerTable(peers)
+	bc.versions = make(map[string]*VersionMessage)
+	bc.conns = make(map[*PeerConn]bool)
+	bc.network = DEFAULT_NETWORK
+
+	genesis := GenesisBlock()
+	if len(bc.Chain) == 0 {
+		if err := store.Append(genesis); err != nil {
+			return nil, err
+		}
+		bc.Chain = []*Block{genesis}
+	} else if bc.Chain[0].Hash() != genesis.Hash() {
+		return nil, fmt.Errorf("%s holds a chain with a different genesis block", dataDir)
 	} else {
-		log.Println("This node could not find neighbors", bc.neighbors)
+		for _, b := range bc.Chain[1:] {
+			if err := bc.utxos.ConnectBlock(b); err != nil {
+				return nil, fmt.Errorf("stored block %d: %w", b.Height, err)
+			}
+		}
+		for _, t := range pool {
+			if err := bc.mempool.Add(t, bc.utxos); err != nil {
+				log.Printf("Dropping stored transaction: %v", err)
+			}
+		}
+		log.Printf("Loaded %d blocks and %d pooled transactions from %s", len(bc.Chain), bc.mempool.Len(), dataDir)
 	}
+	return bc, nil
 }
 
-func (bc *Blockchain) SyncNeighbors() {
-	bc.muxNeighbors.Lock()
-	defer bc.muxNeighbors.Unlock()
-	bc.SetNeightbors()
+// Run keeps the neighbors up to date. The chain is synchronised from a
+// neighbor once its connection is up, if it may have blocks we lack.
+func (bc *Blockchain) Run() {
+	bc.StartSyncNeighbors()
 }
 
 func (bc *Blockchain) StartSyncNeighbors() {
@@ -99,12 +146,15 @@ func (bc *Blockchain) StartSyncNeighbors() {
 	_ = time.AfterFunc(time.Second*BLOCKCHAIN_NEIGBHOR_SYNC_TIME_SEC, bc.StartSyncNeighbors)
 }
 
+// TransactionsPool returns the pending transactions in arrival order.
 func (bc *Blockchain) TransactionsPool() []*Transaction {
-	return bc.TransactionPool
+	return bc.mempool.Transactions()
 }
 
-func (bc *Blockchain) ClearTransactionPool() {
-	bc.TransactionPool = bc.TransactionPool[:0]
+func (bc *Blockchain) savePool() {
+	if err := bc.store.SavePool(bc.mempool.Transactions()); err != nil {
+		log.Printf("ERROR: could not save transaction pool: %v", err)
+	}
 }
 
 func (bc *Blockchain) MarshalJson() ([]byte, error) {
@@ -115,150 +165,157 @@ func (bc *Blockchain) MarshalJson() ([]byte, error) {
 	})
 }
 
-func (bc *Blockchain) CreateBlock(nonce int, previousHash [32]byte) *Block {
-	b := NewBlock(nonce, previousHash, bc.TransactionPool)
-	bc.Chain = append(bc.Chain, b)
-	bc.TransactionPool = []*Transaction{}
-
-	for _, neighborIPAddress := range bc.neighbors {
-
-		endpoint := fmt.Sprintf("http://%s/transactions", neighborIPAddress)
-
-		client := &http.Client{}
-		request, _ := http.NewRequest("DELETE", endpoint, nil)
-		response, err := client.Do(request)
-		if err != nil {
-			log.Printf("%v", response)
+// appendBlock connects b, whose header has been checked, on top of the tip
+// and stores it. Its transactions leave the pool. A block that cannot be
+// stored is disconnected again, so the chain in memory never runs ahead of
+// the one on disk.
+func (bc *Blockchain) appendBlock(b *Block) error {
+	if err := bc.utxos.ConnectBlock(b); err != nil {
+		return &BlockError{Block: b, Reason: err}
+	}
+	if err := bc.store.Append(b); err != nil {
+		if err := bc.utxos.DisconnectBlock(b); err != nil {
+			log.Printf("ERROR: could not disconnect unstored block %d: %v", b.Height, err)
 		}
+		return fmt.Errorf("could not store block %d: %w", b.Height, err)
 	}
-
-	return b
+	bc.Chain = append(bc.Chain, b)
+	bc.mempool.Remove(b.Transactions)
+	bc.savePool()
+	bc.miner.Interrupt()
+	return nil
 }
 
-func (bc *Blockchain) CreateTransaction(sender string, recipient string, value float32, senderPublicKey *ecdsa.PublicKey, s *utils.Signature) bool {
-	isTransacted := bc.AddTransaction(sender, recipient, value, senderPublicKey, s)
-
-	if isTransacted {
-		bc.broadcasTransaction(senderPublicKey, s, sender, recipient, value)
+func (bc *Blockchain) CreateTransaction(t *Transaction) error {
+	if err := bc.AddTransaction(t); err != nil {
+		return err
 	}
 
-	return isTransacted
+	bc.announce(InvItem{Type: INV_TX, Hash: t.Hash()}, nil)
+	return nil
 }
 
-func (bc *Blockchain) broadcasTransaction(senderPublicKey *ecdsa.PublicKey, s *utils.Signature, sender string, recipient string, value float32) {
-	for _, neighborIPAddress := range bc.neighbors {
-		publicKeyStr := fmt.Sprintf("%064x%064x", senderPublicKey.X.Bytes(), senderPublicKey.Y.Bytes())
-		signatureStr := s.String()
-		bt := &TransactionRequest{
-			&sender,
-			&recipient,
-			&publicKeyStr,
-			&value,
-			&signatureStr}
-		marshalJson, _ := json.Marshal(bt)
-
-		buf := bytes.NewBuffer(marshalJson)
-		endpoint := fmt.Sprintf("http://%s/transactions", neighborIPAddress)
+func (bc *Blockchain) AddTransaction(t *Transaction) error {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
 
-		client := &http.Client{}
-		request, _ := http.NewRequest("PUT", endpoint, buf)
-		response, err := client.Do(request)
-		if err != nil {
-			log.Printf("%v", response)
-		}
+	if err := bc.mempool.Add(t, bc.utxos); err != nil {
+		return err
 	}
+	bc.savePool()
+	bc.miner.Interrupt()
+	return nil
 }
 
-func (bc *Blockchain) AddTransaction(sender string, recipient string, value float32, senderPublicKey *ecdsa.PublicKey, s *utils.Signature) bool {
-	t := NewTransaction(sender, recipient, value)
+// selectTransactions picks pending transactions for a block, highest fee rate
+// first, as long as they fit in space bytes. A transaction spending outputs of
+// another pending one is only picked once its parent is.
+func (bc *Blockchain) selectTransactions(space int) []*Transaction {
+	candidates := bc.mempool.Transactions()
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].FeeRate() > candidates[j].FeeRate()
+	})
 
-	if sender == MINING_SENDER {
-		bc.TransactionPool = append(bc.TransactionPool, t)
-		return true
+	// Hashed once up front, as the loop below goes over the candidates again
+	// for every transaction it picks.
+	hashes := make(map[*Transaction][32]byte, len(candidates))
+	pending := make(map[[32]byte]bool, len(candidates))
+	for _, t := range candidates {
+		hashes[t] = t.Hash()
+		pending[hashes[t]] = true
 	}
 
-	if bc.VerifyTransactionSignature(senderPublicKey, s, t) {
-
-		// if bc.CalculateTotalAmount(sender) < value {
-		// 	log.Println("Error: not enough balance in wallet")
-		// 	return false
-		// }
-
-		bc.TransactionPool = append(bc.TransactionPool, t)
-		return true
-	} else {
-		log.Println("ERROR: Could not verify transaction")
+	selected := []*Transaction{}
+	included := make(map[[32]byte]bool)
+	done := make(map[[32]byte]bool)
+	for progress := true; progress; {
+		progress = false
+		for _, t := range candidates {
+			hash := hashes[t]
+			if done[hash] || !parentsIncluded(t, pending, included) {
+				continue
+			}
+			done[hash] = true
+			if size := t.Size(); size <= space {
+				space -= size
+				selected = append(selected, t)
+				included[hash] = true
+				progress = true
+				break
+			}
+		}
 	}
-	return false
+	return selected
 }
 
-func (bc *Blockchain) VerifyTransactionSignature(senderPublicKey *ecdsa.PublicKey, s *utils.Signature, t *Transaction) bool {
-	m, _ := t.MarshalJson()
-	h := sha256.Sum256([]byte(m))
-	return ecdsa.Verify(senderPublicKey, h[:], s.R, s.S)
+func parentsIncluded(t *Transaction, pending map[[32]byte]bool, included map[[32]byte]bool) bool {
+	for _, in := range t.Inputs {
+		parent := in.PreviousOutput.TransactionHash
+		if pending[parent] && !included[parent] {
+			return false
+		}
+	}
+	return true
 }
 
-func (bc *Blockchain) CopyTransactionPool() []*Transaction {
-	transactions := make([]*Transaction, len(bc.TransactionPool))
-
-	for _, t := range bc.TransactionPool {
-		transactions = append(transactions, NewTransaction(t.SenderAddress, t.RecipientAddress, t.Value))
-	}
+func (bc *Blockchain) ValidProof(header *BlockHeader) bool {
+	return HashMeetsTarget(header.Hash(), header.Bits)
+}
 
-	return transactions
+func (bc *Blockchain) LastBlock() *Block {
+	return bc.Chain[len(bc.Chain)-1]
 }
 
-func (bc *Blockchain) ValidProof(nonce int, previousHash [32]byte, transactions []*Transaction, dificulty int) bool {
-	zeros := strings.Repeat("0", dificulty)
-	guessBlock := Block{TimeStamp: 0, Nonce: nonce, PreviousHash: previousHash, Transactions: transactions}
-	guessHashString := fmt.Sprintf("%x", guessBlock.Hash())
-	return guessHashString[:dificulty] == zeros
+// StartMiner mines in the background, paying the rewards to address.
+func (bc *Blockchain) StartMiner(address string) error {
+	return bc.miner.Start(address)
 }
 
-func (bc *Blockchain) ProofOfWOrk() int {
-	transaction := bc.CopyTransactionPool()
-	previousHash := bc.LastBlock().Hash()
-	nonce := 0
-	for !bc.ValidProof(nonce, previousHash, transaction, MINING_DIFICULTY) {
-		nonce++
-	}
-	return nonce
+// StopMiner ends background mining, reporting whether it was running.
+func (bc *Blockchain) StopMiner() bool {
+	return bc.miner.Stop()
 }
 
-func (bc *Blockchain) LastBlock() *Block {
-	return bc.Chain[len(bc.Chain)-1]
+func (bc *Blockchain) MinerStatus() *MinerStatus {
+	return bc.miner.Status()
 }
 
-func (bc *Blockchain) StartMining() {
-	bc.Mining()
-	_ = time.AfterFunc(time.Second*MINING_TIMER_SEC, bc.StartMining)
+// SetMinerWorkers sets how many goroutines mine, one per CPU if workers is not
+// positive.
+func (bc *Blockchain) SetMinerWorkers(workers int) {
+	bc.miner.SetWorkers(workers)
 }
 
-func (bc *Blockchain) Mining() bool {
+// NewBlockTemplate assembles the next block for address to mine on top of our
+// tip, complete but for the nonce.
+func (bc *Blockchain) NewBlockTemplate(address string) (*Block, error) {
 	bc.mux.Lock()
 	defer bc.mux.Unlock()
 
-	if len(bc.TransactionPool) == 0 {
-		return false
+	// Blocks are mined even with an empty pool: the reward is the only way
+	// coins enter circulation. It leads the block and collects the fees of
+	// the transactions picked from the pool. Space for the reward is kept
+	// with the largest value it could carry.
+	bc.mempool.Expire(time.Now())
+	height := uint64(len(bc.Chain))
+	space := MAX_BLOCK_SIZE - NewCoinbaseTransaction(address, math.MaxInt64, height).Size()
+	selected := bc.selectTransactions(space)
+	fees := MINING_REWARD
+	for _, t := range selected {
+		fees += t.Fee
 	}
-
-	bc.AddTransaction(MINING_SENDER, bc.BlockChainAddress, MINING_REWARD, nil, nil)
-	nonce := bc.ProofOfWOrk()
-	previousHash := bc.LastBlock().Hash()
-	bc.CreateBlock(nonce, previousHash)
-	log.Println("action=mining, status=success")
-
-	for _, n := range bc.neighbors {
-		endpoint := fmt.Sprintf("http://%s/consensus", n)
-		client := &http.Client{}
-		request, _ := http.NewRequest("PUT", endpoint, nil)
-		response, err := client.Do(request)
-		if err != nil {
-			log.Printf("%v", response)
-		}
+	reward := NewCoinbaseTransaction(address, fees, height)
+	transactions := append([]*Transaction{reward}, selected...)
+	b := NewBlock(height, NextBits(bc.Chain), bc.LastBlock().Hash(), transactions)
+	// The tip may be stamped ahead of our clock, within MAX_FUTURE_BLOCK, and
+	// a block must come after its parent.
+	b.TimeStamp = max(b.TimeStamp, bc.LastBlock().TimeStamp+1)
+	stateRoot, err := bc.utxos.StateRoot(b)
+	if err != nil {
+		return nil, err
 	}
-
-	return true
+	b.StateRoot = stateRoot
+	return b, nil
 }
 
 func (bc *Blockchain) Print() {
@@ -269,30 +326,97 @@ func (bc *Blockchain) Print() {
 	fmt.Printf("%s\n", strings.Repeat("#", 30))
 }
 
-func (bc *Blockchain) ValidChain(chain []*Block) bool {
-	previousBlock := chain[0]
-	currentIndex := 1
-
-	for currentIndex < len(chain) {
-		block := chain[currentIndex]
-		if block.PreviousHash != previousBlock.Hash() {
-			return false
+// validCoinbase requires the block to start with its only reward, which pays
+// MINING_REWARD and the fees of the block to a single address and is numbered
+// with the block height.
+func validCoinbase(b *Block) error {
+	if len(b.Transactions) == 0 || !b.Transactions[0].IsCoinbase() {
+		return &TransactionError{Reason: ErrInvalidCoinbase, Detail: "block does not start with a reward"}
+	}
+	for _, t := range b.Transactions[1:] {
+		if t.IsCoinbase() {
+			return rejectTransaction(t, ErrInvalidCoinbase, "more than one reward in block")
 		}
+	}
 
-		if !bc.ValidProof(block.Nonce, block.PreviousHash, block.Transactions, MINING_DIFICULTY) {
-			return false
-		}
+	expected := MINING_REWARD
+	for _, t := range b.Transactions[1:] {
+		expected += t.Fee
+	}
+
+	reward := b.Transactions[0]
+	if len(reward.Outputs) != 1 || reward.Fee != 0 {
+		return rejectTransaction(reward, ErrInvalidCoinbase, "reward has %d outputs and a fee of %s", len(reward.Outputs), reward.Fee)
+	}
+	if reward.Outputs[0].Value != expected {
+		return rejectTransaction(reward, ErrInvalidCoinbase, "reward is %s, expected %s", reward.Outputs[0].Value, expected)
+	}
+	if !utils.ValidAddress(reward.Outputs[0].Address) {
+		return rejectTransaction(reward, ErrInvalidAddress, "reward recipient %q", reward.Outputs[0].Address)
+	}
+	if reward.CoinbaseHeight != b.Height {
+		return rejectTransaction(reward, ErrInvalidCoinbase, "reward for height %d in block %d", reward.CoinbaseHeight, b.Height)
+	}
+	return nil
+}
 
-		previousBlock = block
-		currentIndex++
+// Headers returns up to MAX_HEADERS_PER_REQUEST block headers starting at
+// height from.
+func (bc *Blockchain) Headers(from int) []*BlockHeader {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
 
+	headers := []*BlockHeader{}
+	for i := from; i >= 0 && i < len(bc.Chain) && len(headers) < MAX_HEADERS_PER_REQUEST; i++ {
+		header := bc.Chain[i].BlockHeader
+		headers = append(headers, &header)
 	}
+	return headers
+}
 
-	return true
+// TransactionProof builds an inclusion proof for the confirmed transaction
+// with the given hash.
+func (bc *Blockchain) TransactionProof(hash [32]byte) (*InclusionProof, error) {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+
+	for _, b := range bc.Chain {
+		for i, t := range b.Transactions {
+			if t.Hash() != hash {
+				continue
+			}
+
+			encoded, err := json.Marshal(t)
+			if err != nil {
+				return nil, err
+			}
+			return &InclusionProof{
+				TransactionHash: hash,
+				Transaction:     encoded,
+				BlockHash:       b.Hash(),
+				Header:          b.BlockHeader,
+				Index:           i,
+				Branch:          MerkleBranch(transactionLeaves(b.Transactions), i),
+			}, nil
+		}
+	}
+	return nil, ErrTransactionNotFound
 }
 
+// AmountResponse carries the balance together with the tip it was computed
+// at, so light clients can check the answer against their header chain.
 type AmountResponse struct {
-	Amount float32 `json:"amount"`
+	Amount    Amount   `json:"amount"`
+	Height    uint64   `json:"height"`
+	BlockHash [32]byte `json:"block_hash"`
+}
+
+type HeadersResponse struct {
+	Headers []*BlockHeader `json:"headers"`
+}
+
+type UTXOResponse struct {
+	UTXOs []*UTXO `json:"utxos"`
 }
 
 func (bc *Blockchain) UnmarshalJson(data []byte) error {
@@ -309,32 +433,86 @@ func (bc *Blockchain) UnmarshalJson(data []byte) error {
 	return nil
 }
 
-func (bc *Blockchain) ResolveConflicts() bool {
-	var longestChain []*Block = nil
-	maxLength := len(bc.Chain)
+// reorganize replaces the blocks above fork with blocks, whose headers have
+// been checked, connecting each against the unspent outputs. If one of them
+// is invalid, or the new chain cannot be stored, the previous chain is
+// restored and an error returned.
+// Transactions the new blocks include leave the pool, those of our blocks
+// that are orphaned go back to it ahead of the pending ones, and whatever is
+// no longer valid on top of the new chain is dropped.
+func (bc *Blockchain) reorganize(fork int, blocks []*Block) error {
+	orphaned := append([]*Block{}, bc.Chain[fork:]...)
+	for i := len(orphaned) - 1; i >= 0; i-- {
+		if err := bc.utxos.DisconnectBlock(orphaned[i]); err != nil {
+			log.Printf("ERROR: could not disconnect block %d: %v", orphaned[i].Height, err)
+		}
+	}
+	for i, b := range blocks {
+		if err := bc.utxos.ConnectBlock(b); err != nil {
+			for j := i - 1; j >= 0; j-- {
+				bc.utxos.DisconnectBlock(blocks[j])
+			}
+			for _, o := range orphaned {
+				bc.utxos.ConnectBlock(o)
+			}
+			return &BlockError{Block: b, Reason: err}
+		}
+	}
+	if err := bc.replaceChain(append(bc.Chain[:fork:fork], blocks...), fork); err != nil {
+		for i := len(blocks) - 1; i >= 0; i-- {
+			bc.utxos.DisconnectBlock(blocks[i])
+		}
+		for _, o := range orphaned {
+			bc.utxos.ConnectBlock(o)
+		}
+		return err
+	}
+
+	for _, b := range blocks {
+		bc.mempool.Remove(b.Transactions)
+	}
+	var candidates []*Transaction
+	for _, b := range orphaned {
+		candidates = append(candidates, b.Transactions[1:]...)
+	}
+	candidates = append(candidates, bc.mempool.Clear()...)
 
-	for _, n := range bc.neighbors {
-		endpoint := fmt.Sprintf("http://%s/chain", n)
-		resp, _ := http.Get(endpoint)
-		if resp.StatusCode == 200 {
-			var bcResponse Blockchain
-			decoder := json.NewDecoder(resp.Body)
-			_ = decoder.Decode(&bcResponse)
+	for _, t := range candidates {
+		if err := bc.mempool.Add(t, bc.utxos); err != nil {
+			log.Printf("Dropping transaction after reorganisation: %v", err)
+		}
+	}
+	bc.savePool()
+	bc.miner.Interrupt()
 
-			chain := bcResponse.Chain
+	if len(orphaned) > 0 {
+		log.Printf("Reorganised chain at height %d: %d blocks orphaned, %d connected", fork, len(orphaned), len(blocks))
+	}
+	return nil
+}
 
-			if len(chain) > maxLength && bc.ValidChain(chain) {
-				maxLength = len(chain)
-				longestChain = chain
-			}
+// replaceChain rewrites the stored log from fork, the first block where chain
+// differs from the current one, and swaps chain in. If the log cannot be
+// rewritten the current chain is written back and stays in place.
+func (bc *Blockchain) replaceChain(chain []*Block, fork int) error {
+	if err := bc.storeChain(chain, fork); err != nil {
+		if err := bc.storeChain(bc.Chain, fork); err != nil {
+			log.Printf("ERROR: could not restore stored chain: %v", err)
 		}
+		return fmt.Errorf("could not store chain: %w", err)
 	}
+	bc.Chain = chain
+	return nil
+}
 
-	if longestChain != nil {
-		bc.Chain = longestChain
-		log.Println("Conflics solved! Blockchain was replaced")
-		return true
+func (bc *Blockchain) storeChain(chain []*Block, fork int) error {
+	if err := bc.store.Truncate(fork); err != nil {
+		return err
+	}
+	for _, b := range chain[fork:] {
+		if err := bc.store.Append(b); err != nil {
+			return err
+		}
 	}
-	log.Println("Conflics solved! Blockchain was NOT replaced")
-	return false
+	return nil
 }
@@ -0,0 +1,169 @@
+package blockchain
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+
+	"github.com/jvsena42/go_blockchain/utils"
+)
+
+type testKey struct {
+	private *ecdsa.PrivateKey
+	public  string
+	address string
+}
+
+func newTestKey(t *testing.T) *testKey {
+	t.Helper()
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testKey{
+		private: private,
+		public:  utils.PublicKeyToString(&private.PublicKey),
+		address: utils.PublicKeyToAddress(&private.PublicKey),
+	}
+}
+
+// pay spends outputs owned by k.
+func (k *testKey) pay(t *testing.T, spent []OutPoint, outputs []*TxOutput, fee Amount) *Transaction {
+	t.Helper()
+	inputs := make([]*TxInput, len(spent))
+	for i, op := range spent {
+		inputs[i] = &TxInput{PreviousOutput: op, PublicKey: k.public}
+	}
+	tx := NewTransaction(inputs, outputs, fee)
+
+	hash := tx.Hash()
+	for _, in := range inputs {
+		r, s, err := ecdsa.Sign(rand.Reader, k.private, hash[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		in.Signature = (&utils.Signature{R: r, S: s}).String()
+	}
+	return tx
+}
+
+func newTestChain(t *testing.T, dir string) *Blockchain {
+	t.Helper()
+	bc, err := NewBlockchain(0, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bc.store.Close() })
+	return bc
+}
+
+// mineBlock mines a block paying address on top of the tip.
+func mineBlock(t *testing.T, bc *Blockchain, address string) *Block {
+	t.Helper()
+	b := bc.miner.MineBlock(address, nil)
+	if b == nil {
+		t.Fatal("block not mined")
+	}
+	return b
+}
+
+func TestMiningKeepsTipWhenStoreFails(t *testing.T) {
+	bc := newTestChain(t, t.TempDir())
+	address := newTestKey(t).address
+
+	bc.store.file.Close()
+	if bc.miner.MineBlock(address, nil) != nil {
+		t.Fatal("block mined without being stored")
+	}
+	if len(bc.Chain) != 1 {
+		t.Errorf("chain has %d blocks, want the genesis block only", len(bc.Chain))
+	}
+	if balance := bc.CalculateTotalAmount(address); balance != 0 {
+		t.Errorf("reward of the unstored block is spendable: %s", balance)
+	}
+}
+
+func TestReorganizeReturnsOrphanedTransactions(t *testing.T) {
+	sender, recipient := newTestKey(t), newTestKey(t)
+
+	bc := newTestChain(t, t.TempDir())
+	reward := mineBlock(t, bc, sender.address).Transactions[0]
+	tx := sender.pay(t, []OutPoint{{TransactionHash: reward.Hash()}}, []*TxOutput{
+		{Address: recipient.address, Value: COIN / 2},
+		{Address: sender.address, Value: COIN/2 - 10000},
+	}, 10000)
+	if err := bc.AddTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	mineBlock(t, bc, sender.address)
+	if bc.mempool.Len() != 0 {
+		t.Fatal("mined transfer still pooled")
+	}
+
+	// A heavier branch from the genesis block that never saw the transfer,
+	// but pays the sender the same first reward it spends.
+	branch := newTestChain(t, t.TempDir())
+	for i := 0; i < 3; i++ {
+		mineBlock(t, branch, sender.address)
+	}
+
+	bc.mux.Lock()
+	err := bc.reorganize(1, branch.Chain[1:])
+	bc.mux.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bc.LastBlock().Hash() != branch.LastBlock().Hash() {
+		t.Fatal("chain not replaced by the heavier branch")
+	}
+	if got := bc.store.Height(); got != len(branch.Chain) {
+		t.Errorf("store holds %d blocks, want %d", got, len(branch.Chain))
+	}
+	if bc.mempool.Len() != 1 {
+		t.Fatalf("pool holds %d transactions, want the orphaned transfer", bc.mempool.Len())
+	}
+	if bc.mempool.Get(tx.Hash()) == nil {
+		t.Error("pool does not hold the orphaned transfer")
+	}
+}
+
+func TestSelectTransactionsOrdersParentsFirst(t *testing.T) {
+	bc := newTestChain(t, t.TempDir())
+	k := newTestKey(t)
+	reward := mineBlock(t, bc, k.address).Transactions[0]
+
+	// The child pays a higher fee rate, but spends the parent's output.
+	parent := k.pay(t, []OutPoint{{TransactionHash: reward.Hash()}}, []*TxOutput{{Address: k.address, Value: COIN - 10000}}, 10000)
+	child := k.pay(t, []OutPoint{{TransactionHash: parent.Hash()}}, []*TxOutput{{Address: k.address, Value: COIN - 10000 - 50000}}, 50000)
+	for _, tx := range []*Transaction{parent, child} {
+		if err := bc.AddTransaction(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bc.mux.Lock()
+	selected := bc.selectTransactions(MAX_BLOCK_SIZE)
+	cramped := bc.selectTransactions(parent.Size() + child.Size() - 1)
+	bc.mux.Unlock()
+
+	if len(selected) != 2 || selected[0].Hash() != parent.Hash() || selected[1].Hash() != child.Hash() {
+		t.Fatalf("selected %d transactions, want the parent before the child", len(selected))
+	}
+	if len(cramped) != 1 || cramped[0].Hash() != parent.Hash() {
+		t.Errorf("with room for one, selected %d transactions, want the parent", len(cramped))
+	}
+
+	b := mineBlock(t, bc, k.address)
+	if len(b.Transactions) != 3 {
+		t.Fatalf("block holds %d transactions, want the reward, parent and child", len(b.Transactions))
+	}
+	if got, want := b.Transactions[0].Outputs[0].Value, MINING_REWARD+10000+50000; got != want {
+		t.Errorf("reward pays %s, want %s", got, want)
+	}
+	// The fees k paid come back to it as the miner.
+	if got, want := bc.CalculateTotalAmount(k.address), 2*MINING_REWARD; got != want {
+		t.Errorf("balance %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,126 @@
+package blockchain
+
+import (
+	"math/big"
+	"time"
+)
+
+const (
+	// Targets are stored in compact form: the high byte is the length of the
+	// target in bytes and the low three bytes its most significant digits.
+	POW_LIMIT_BITS     = 0x2000ffff // easiest target allowed, two leading zero hex digits
+	INITIAL_BITS       = 0x1f0fffff // three leading zero hex digits
+	RETARGET_INTERVAL  = 10
+	BLOCK_TIME_GOAL    = 20 * time.Second
+	MAX_RETARGET_RATIO = 4
+	MAX_FUTURE_BLOCK   = 2 * time.Hour
+)
+
+var powLimit = CompactToBig(POW_LIMIT_BITS)
+
+// CompactToBig expands a compact target into the 256-bit number that a block
+// hash must not exceed.
+func CompactToBig(bits uint32) *big.Int {
+	exponent := uint(bits >> 24)
+	mantissa := big.NewInt(int64(bits & 0x007fffff))
+
+	if exponent <= 3 {
+		return mantissa.Rsh(mantissa, 8*(3-exponent))
+	}
+	return mantissa.Lsh(mantissa, 8*(exponent-3))
+}
+
+// BigToCompact encodes target in compact form, keeping its three most
+// significant bytes.
+func BigToCompact(target *big.Int) uint32 {
+	if target.Sign() <= 0 {
+		return 0
+	}
+
+	exponent := uint((target.BitLen() + 7) / 8)
+	var mantissa uint32
+	if exponent <= 3 {
+		mantissa = uint32(target.Uint64() << (8 * (3 - exponent)))
+	} else {
+		mantissa = uint32(new(big.Int).Rsh(target, 8*(exponent-3)).Uint64())
+	}
+
+	// The 0x00800000 bit is a sign bit in the compact format, so move a
+	// mantissa that would set it into the next exponent.
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+	return uint32(exponent<<24) | mantissa
+}
+
+// HashMeetsTarget reports whether hash, read as a big endian number, is not
+// above the target encoded in bits.
+func HashMeetsTarget(hash [32]byte, bits uint32) bool {
+	target := CompactToBig(bits)
+	if target.Sign() <= 0 || target.Cmp(powLimit) > 0 {
+		return false
+	}
+	return new(big.Int).SetBytes(hash[:]).Cmp(target) <= 0
+}
+
+// Work is the expected number of hashes needed to find the block's nonce,
+// 2^256 / (target + 1).
+func (h *BlockHeader) Work() *big.Int {
+	target := CompactToBig(h.Bits)
+	if target.Sign() <= 0 {
+		return new(big.Int)
+	}
+	work := new(big.Int).Lsh(big.NewInt(1), 256)
+	return work.Div(work, target.Add(target, big.NewInt(1)))
+}
+
+// ChainWork sums the work of every block in chain.
+func ChainWork(chain []*Block) *big.Int {
+	work := new(big.Int)
+	for _, b := range chain {
+		work.Add(work, b.Work())
+	}
+	return work
+}
+
+// NextBits returns the target the block following chain has to meet. Every
+// RETARGET_INTERVAL blocks the target is scaled by how long the last interval
+// actually took compared to BLOCK_TIME_GOAL per block, by at most a factor of
+// MAX_RETARGET_RATIO either way and never beyond POW_LIMIT_BITS.
+func NextBits(chain []*Block) uint32 {
+	return nextBits(len(chain), func(i int) *BlockHeader { return &chain[i].BlockHeader })
+}
+
+// NextHeaderBits is NextBits for clients that only track headers.
+func NextHeaderBits(headers []*BlockHeader) uint32 {
+	return nextBits(len(headers), func(i int) *BlockHeader { return headers[i] })
+}
+
+func nextBits(height int, header func(i int) *BlockHeader) uint32 {
+	last := header(height - 1)
+
+	// The genesis block has a fixed timestamp, so the first window that can
+	// be measured is the one after it.
+	if height%RETARGET_INTERVAL != 0 || height <= RETARGET_INTERVAL {
+		return last.Bits
+	}
+
+	first := header(height - RETARGET_INTERVAL)
+	expected := int64(BLOCK_TIME_GOAL) * (RETARGET_INTERVAL - 1)
+	actual := last.TimeStamp - first.TimeStamp
+	if actual < expected/MAX_RETARGET_RATIO {
+		actual = expected / MAX_RETARGET_RATIO
+	}
+	if actual > expected*MAX_RETARGET_RATIO {
+		actual = expected * MAX_RETARGET_RATIO
+	}
+
+	target := CompactToBig(last.Bits)
+	target.Mul(target, big.NewInt(actual))
+	target.Div(target, big.NewInt(expected))
+	if target.Cmp(powLimit) > 0 {
+		target = powLimit
+	}
+	return BigToCompact(target)
+}
@@ -0,0 +1,65 @@
+package blockchain
+
+import (
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestCompactRoundTrip(t *testing.T) {
+	for _, bits := range []uint32{INITIAL_BITS, POW_LIMIT_BITS, 0x1d00ffff, 0x1b0404cb, 0x03123456} {
+		if got := BigToCompact(CompactToBig(bits)); got != bits {
+			t.Errorf("BigToCompact(CompactToBig(%#08x)) = %#08x", bits, got)
+		}
+	}
+
+	// A mantissa with its top bit set moves into the next exponent.
+	if got := BigToCompact(big.NewInt(0x80)); got != 0x02008000 {
+		t.Errorf("BigToCompact(0x80) = %#08x, want 0x02008000", got)
+	}
+	if got := CompactToBig(0x03123456); got.Int64() != 0x123456 {
+		t.Errorf("CompactToBig(0x03123456) = %#x", got)
+	}
+}
+
+// spacedChain returns a chain of height blocks after the genesis block, each
+// stamped spacing after its parent and carrying bits.
+func spacedChain(height int, spacing time.Duration, bits uint32) []*Block {
+	chain := []*Block{GenesisBlock()}
+	for i := 1; i <= height; i++ {
+		chain = append(chain, &Block{BlockHeader: BlockHeader{TimeStamp: int64(i) * int64(spacing), Bits: bits}})
+	}
+	return chain
+}
+
+func scaledBits(bits uint32, num int64, den int64) uint32 {
+	target := CompactToBig(bits)
+	target.Mul(target, big.NewInt(num))
+	target.Div(target, big.NewInt(den))
+	return BigToCompact(target)
+}
+
+func TestNextBitsRetargets(t *testing.T) {
+	tests := []struct {
+		name    string
+		height  int
+		spacing time.Duration
+		bits    uint32
+		want    uint32
+	}{
+		{"between retargets", 2*RETARGET_INTERVAL - 1, time.Second, INITIAL_BITS, INITIAL_BITS},
+		{"first window", RETARGET_INTERVAL, time.Second, INITIAL_BITS, INITIAL_BITS},
+		{"on goal", 2 * RETARGET_INTERVAL, BLOCK_TIME_GOAL, INITIAL_BITS, INITIAL_BITS},
+		{"twice as fast", 2 * RETARGET_INTERVAL, BLOCK_TIME_GOAL / 2, INITIAL_BITS, scaledBits(INITIAL_BITS, 1, 2)},
+		{"twice as slow", 2 * RETARGET_INTERVAL, BLOCK_TIME_GOAL * 2, INITIAL_BITS, scaledBits(INITIAL_BITS, 2, 1)},
+		{"clamped fast", 2 * RETARGET_INTERVAL, time.Millisecond, INITIAL_BITS, scaledBits(INITIAL_BITS, 1, MAX_RETARGET_RATIO)},
+		{"clamped slow", 2 * RETARGET_INTERVAL, BLOCK_TIME_GOAL * 100, INITIAL_BITS, scaledBits(INITIAL_BITS, MAX_RETARGET_RATIO, 1)},
+		{"capped at limit", 2 * RETARGET_INTERVAL, BLOCK_TIME_GOAL * 2, POW_LIMIT_BITS, POW_LIMIT_BITS},
+	}
+	for _, tt := range tests {
+		chain := spacedChain(tt.height-1, tt.spacing, tt.bits)
+		if got := NextBits(chain); got != tt.want {
+			t.Errorf("%s: NextBits = %#08x, want %#08x", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package blockchain
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// TRANSACTION_ENCODING_VERSION leads every encoded transaction so the format
+// can change without old and new encodings being mistaken for one another.
+const TRANSACTION_ENCODING_VERSION = 1
+
+var ErrMalformedEncoding = errors.New("malformed binary encoding")
+
+// The binary encoding is canonical: integers are fixed-size big endian,
+// counts and string lengths are minimal unsigned varints, hashes are raw bytes
+// and fields follow declaration order, so every value has exactly one
+// encoding and decoding accepts nothing else. Every hash is taken over it:
+//
+//	block hash        sha256(BlockHeader.Bytes)
+//	transaction id    sha256(Transaction.SigningBytes), which inputs sign
+//	merkle leaf       sha256(Transaction.Bytes)
+//	state root leaf   sha256(UTXO.Bytes)
+//
+// and it is what peers send each other.
+
+// Bytes encodes the fixed-size header, BLOCK_HEADER_SIZE bytes.
+func (h *BlockHeader) Bytes() []byte {
+	buf := make([]byte, 0, BLOCK_HEADER_SIZE)
+	buf = binary.BigEndian.AppendUint32(buf, h.Version)
+	buf = binary.BigEndian.AppendUint64(buf, h.Height)
+	buf = append(buf, h.PreviousHash[:]...)
+	buf = append(buf, h.MerkleRoot[:]...)
+	buf = append(buf, h.StateRoot[:]...)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(h.TimeStamp))
+	buf = binary.BigEndian.AppendUint32(buf, h.Bits)
+	buf = binary.BigEndian.AppendUint64(buf, h.Nonce)
+	return buf
+}
+
+// Bytes encodes the whole transaction, signatures included, as peers send it.
+func (t *Transaction) Bytes() []byte {
+	return t.encode(true)
+}
+
+// SigningBytes encodes what the owners of the inputs sign: the outputs being
+// spent, the outputs being created and the fee, without any keys or
+// signatures.
+func (t *Transaction) SigningBytes() []byte {
+	return t.encode(false)
+}
+
+func (t *Transaction) encode(withSignatures bool) []byte {
+	buf := []byte{TRANSACTION_ENCODING_VERSION}
+	buf = binary.AppendUvarint(buf, uint64(len(t.Inputs)))
+	for _, in := range t.Inputs {
+		buf = appendOutPoint(buf, in.PreviousOutput)
+		if withSignatures {
+			buf = appendString(buf, in.PublicKey)
+			buf = appendString(buf, in.Signature)
+		}
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(t.Outputs)))
+	for _, out := range t.Outputs {
+		buf = appendOutput(buf, out)
+	}
+	buf = binary.BigEndian.AppendUint64(buf, uint64(t.Fee))
+	buf = binary.BigEndian.AppendUint64(buf, t.CoinbaseHeight)
+	return buf
+}
+
+// Bytes encodes the header followed by the transactions.
+func (b *Block) Bytes() []byte {
+	buf := b.BlockHeader.Bytes()
+	buf = binary.AppendUvarint(buf, uint64(len(b.Transactions)))
+	for _, t := range b.Transactions {
+		buf = append(buf, t.Bytes()...)
+	}
+	return buf
+}
+
+// Bytes encodes an unspent output as the outpoint naming it followed by the
+// output itself.
+func (u *UTXO) Bytes() []byte {
+	return appendOutput(appendOutPoint(nil, u.OutPoint), u.Output)
+}
+
+func appendOutPoint(buf []byte, op OutPoint) []byte {
+	buf = append(buf, op.TransactionHash[:]...)
+	return binary.BigEndian.AppendUint32(buf, op.Index)
+}
+
+func appendOutput(buf []byte, out *TxOutput) []byte {
+	buf = appendString(buf, out.Address)
+	return binary.BigEndian.AppendUint64(buf, uint64(out.Value))
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// decoder reads the binary encoding. The first error sticks, so a caller can
+// read a whole value and check err once.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) fail(format string, a ...any) {
+	if d.err == nil {
+		d.err = fmt.Errorf("%w: %s", ErrMalformedEncoding, fmt.Sprintf(format, a...))
+	}
+}
+
+func (d *decoder) bytes(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n > len(d.buf) {
+		d.fail("%d bytes needed, %d left", n, len(d.buf))
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) uint8() uint8 {
+	if b := d.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) uint32() uint32 {
+	if b := d.bytes(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *decoder) uint64() uint64 {
+	if b := d.bytes(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (d *decoder) hash() (h [32]byte) {
+	copy(h[:], d.bytes(32))
+	return h
+}
+
+// count reads a varint count of items at least minSize bytes long each, and
+// refuses counts the remaining input cannot hold.
+func (d *decoder) count(minSize int) int {
+	if d.err != nil {
+		return 0
+	}
+	n, k := binary.Uvarint(d.buf)
+	if k <= 0 || k != len(binary.AppendUvarint(nil, n)) {
+		d.fail("bad varint")
+		return 0
+	}
+	d.buf = d.buf[k:]
+	if n > uint64(len(d.buf)/minSize) {
+		d.fail("count %d exceeds the input", n)
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes(d.count(1)))
+}
+
+// end fails if anything is left after the value.
+func (d *decoder) end() error {
+	if d.err == nil && len(d.buf) != 0 {
+		d.fail("%d trailing bytes", len(d.buf))
+	}
+	return d.err
+}
+
+func (d *decoder) header() *BlockHeader {
+	h := new(BlockHeader)
+	h.Version = d.uint32()
+	h.Height = d.uint64()
+	h.PreviousHash = d.hash()
+	h.MerkleRoot = d.hash()
+	h.StateRoot = d.hash()
+	h.TimeStamp = int64(d.uint64())
+	h.Bits = d.uint32()
+	h.Nonce = d.uint64()
+	return h
+}
+
+func (d *decoder) outPoint() OutPoint {
+	return OutPoint{TransactionHash: d.hash(), Index: d.uint32()}
+}
+
+func (d *decoder) output() *TxOutput {
+	return &TxOutput{Address: d.string(), Value: Amount(d.uint64())}
+}
+
+func (d *decoder) transaction() *Transaction {
+	if v := d.uint8(); d.err == nil && v != TRANSACTION_ENCODING_VERSION {
+		d.fail("unknown transaction encoding version %d", v)
+	}
+
+	t := new(Transaction)
+	t.Inputs = make([]*TxInput, d.count(32+4+2))
+	for i := range t.Inputs {
+		in := new(TxInput)
+		in.PreviousOutput = d.outPoint()
+		in.PublicKey = d.string()
+		in.Signature = d.string()
+		t.Inputs[i] = in
+	}
+	t.Outputs = make([]*TxOutput, d.count(1+8))
+	for i := range t.Outputs {
+		t.Outputs[i] = d.output()
+	}
+	t.Fee = Amount(d.uint64())
+	t.CoinbaseHeight = d.uint64()
+	return t
+}
+
+func DecodeHeader(data []byte) (*BlockHeader, error) {
+	d := &decoder{buf: data}
+	h := d.header()
+	return h, d.end()
+}
+
+func DecodeTransaction(data []byte) (*Transaction, error) {
+	d := &decoder{buf: data}
+	t := d.transaction()
+	return t, d.end()
+}
+
+func DecodeBlock(data []byte) (*Block, error) {
+	d := &decoder{buf: data}
+	b := &Block{BlockHeader: *d.header()}
+	b.Transactions = make([]*Transaction, d.count(1+1+1+8+8))
+	for i := range b.Transactions {
+		b.Transactions[i] = d.transaction()
+	}
+	return b, d.end()
+}
@@ -0,0 +1,195 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// The vectors below pin the canonical encoding: a change to any of them
+// changes block hashes, transaction ids or state roots, and so consensus.
+
+func vectorHeader() *BlockHeader {
+	h := &BlockHeader{Version: 1, Height: 2, TimeStamp: 0x0102030405060708, Bits: 0x1f0fffff, Nonce: 42}
+	copy(h.PreviousHash[:], bytes.Repeat([]byte{0x11}, 32))
+	copy(h.MerkleRoot[:], bytes.Repeat([]byte{0x22}, 32))
+	copy(h.StateRoot[:], bytes.Repeat([]byte{0x33}, 32))
+	return h
+}
+
+func vectorOutPoint() OutPoint {
+	op := OutPoint{Index: 1}
+	copy(op.TransactionHash[:], bytes.Repeat([]byte{0xaa}, 32))
+	return op
+}
+
+func vectorTransaction() *Transaction {
+	return &Transaction{
+		Inputs:  []*TxInput{{PreviousOutput: vectorOutPoint(), PublicKey: "pk", Signature: "sig"}},
+		Outputs: []*TxOutput{{Address: "addr", Value: 5000}},
+		Fee:     10,
+	}
+}
+
+func fromHex(t *testing.T, parts ...string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.Join(parts, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func checkHash(t *testing.T, name string, got [32]byte, want string) {
+	t.Helper()
+	if hex.EncodeToString(got[:]) != want {
+		t.Errorf("%s = %x, want %s", name, got, want)
+	}
+}
+
+func TestHeaderVector(t *testing.T) {
+	h := vectorHeader()
+	want := fromHex(t,
+		"00000001",         // version
+		"0000000000000002", // height
+		strings.Repeat("11", 32),
+		strings.Repeat("22", 32),
+		strings.Repeat("33", 32),
+		"0102030405060708", // timestamp
+		"1f0fffff",         // bits
+		"000000000000002a", // nonce
+	)
+
+	if got := h.Bytes(); !bytes.Equal(got, want) {
+		t.Fatalf("header encoding\n got %x\nwant %x", got, want)
+	}
+	if len(want) != BLOCK_HEADER_SIZE {
+		t.Errorf("header is %d bytes, BLOCK_HEADER_SIZE is %d", len(want), BLOCK_HEADER_SIZE)
+	}
+	checkHash(t, "header hash", h.Hash(), "a08a20ee1f32fde35e627e2ca2894f7102ae508b7a71dbc4ca5c6d05080d90d9")
+}
+
+func TestTransactionVector(t *testing.T) {
+	tx := vectorTransaction()
+	signing := fromHex(t,
+		"01", // encoding version
+		"01", // inputs
+		strings.Repeat("aa", 32), "00000001",
+		"01",             // outputs
+		"04", "61646472", // "addr"
+		"0000000000001388", // value
+		"000000000000000a", // fee
+		"0000000000000000", // coinbase height
+	)
+	full := fromHex(t,
+		"01",
+		"01",
+		strings.Repeat("aa", 32), "00000001",
+		"02", "706b", // "pk"
+		"03", "736967", // "sig"
+		"01",
+		"04", "61646472",
+		"0000000000001388",
+		"000000000000000a",
+		"0000000000000000",
+	)
+
+	if got := tx.SigningBytes(); !bytes.Equal(got, signing) {
+		t.Errorf("signing bytes\n got %x\nwant %x", got, signing)
+	}
+	if got := tx.Bytes(); !bytes.Equal(got, full) {
+		t.Errorf("bytes\n got %x\nwant %x", got, full)
+	}
+	checkHash(t, "transaction id", tx.Hash(), "fdcebcf2a09eb90fe831f63eb69e74fd1260b269eeef560b7091f9e41b85e6fb")
+	checkHash(t, "witness hash", tx.WitnessHash(), "6daa8b36e14bf023296acac19f1d29dd53de9e2d63512927a42256a566412754")
+}
+
+func TestUTXORootVector(t *testing.T) {
+	u := &UTXO{OutPoint: vectorOutPoint(), Output: &TxOutput{Address: "addr", Value: 5000}}
+	want := fromHex(t, strings.Repeat("aa", 32), "00000001", "04", "61646472", "0000000000001388")
+	if got := u.Bytes(); !bytes.Equal(got, want) {
+		t.Errorf("utxo bytes\n got %x\nwant %x", got, want)
+	}
+	checkHash(t, "utxo hash", u.Hash(), "b5a35a00597bf32cfb26d631b313760bb1d15337336e81376bb5c4c7be139ed1")
+
+	// The leaves are ordered by outpoint, so the 0b... output comes first.
+	s := NewUTXOSet()
+	s.add(u.OutPoint, u.Output)
+	other := OutPoint{}
+	copy(other.TransactionHash[:], bytes.Repeat([]byte{0x0b}, 32))
+	s.add(other, &TxOutput{Address: "other", Value: 7})
+	checkHash(t, "utxo root", s.Root(), "8c5f9f159b147534616ff725d97c4752879d3d75755ee575d6e1f22892820439")
+}
+
+func vectorBlock() *Block {
+	coinbase := NewCoinbaseTransaction("miner", MINING_REWARD, 2)
+	return &Block{BlockHeader: *vectorHeader(), Transactions: []*Transaction{coinbase, vectorTransaction()}}
+}
+
+func TestDecodeRoundTrip(t *testing.T) {
+	b := vectorBlock()
+	decoded, err := DecodeBlock(b.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded.Bytes(), b.Bytes()) || decoded.Hash() != b.Hash() {
+		t.Error("block changed through encoding")
+	}
+
+	tx := vectorTransaction()
+	decodedTx, err := DecodeTransaction(tx.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decodedTx.Hash() != tx.Hash() || decodedTx.WitnessHash() != tx.WitnessHash() {
+		t.Error("transaction changed through encoding")
+	}
+}
+
+func TestDecodeRejectsTruncatedInput(t *testing.T) {
+	block := vectorBlock().Bytes()
+	for n := 0; n < len(block); n++ {
+		if _, err := DecodeBlock(block[:n]); !errors.Is(err, ErrMalformedEncoding) {
+			t.Fatalf("block cut to %d of %d bytes decoded with error %v", n, len(block), err)
+		}
+	}
+
+	tx := vectorTransaction().Bytes()
+	for n := 0; n < len(tx); n++ {
+		if _, err := DecodeTransaction(tx[:n]); !errors.Is(err, ErrMalformedEncoding) {
+			t.Fatalf("transaction cut to %d of %d bytes decoded with error %v", n, len(tx), err)
+		}
+	}
+}
+
+func TestDecodeRejectsOversizedInput(t *testing.T) {
+	block := vectorBlock().Bytes()
+	if _, err := DecodeBlock(append(block, 0)); !errors.Is(err, ErrMalformedEncoding) {
+		t.Errorf("block with a trailing byte decoded with error %v", err)
+	}
+	tx := vectorTransaction().Bytes()
+	if _, err := DecodeTransaction(append(tx, 0)); !errors.Is(err, ErrMalformedEncoding) {
+		t.Errorf("transaction with a trailing byte decoded with error %v", err)
+	}
+
+	// Counts larger than the input could hold are refused before anything
+	// is allocated for them.
+	huge := binary.AppendUvarint(vectorHeader().Bytes(), 1<<40)
+	if _, err := DecodeBlock(huge); !errors.Is(err, ErrMalformedEncoding) {
+		t.Errorf("block claiming 2^40 transactions decoded with error %v", err)
+	}
+	long := append([]byte{TRANSACTION_ENCODING_VERSION, 1}, tx[2:2+36]...)
+	long = binary.AppendUvarint(long, 1<<30)
+	if _, err := DecodeTransaction(long); !errors.Is(err, ErrMalformedEncoding) {
+		t.Errorf("transaction with a 1 GiB public key decoded with error %v", err)
+	}
+
+	// Varints must be minimal, so each value has a single encoding.
+	padded := append([]byte{TRANSACTION_ENCODING_VERSION}, 0x81, 0x00)
+	if _, err := DecodeTransaction(padded); !errors.Is(err, ErrMalformedEncoding) {
+		t.Errorf("non-minimal varint decoded with error %v", err)
+	}
+}
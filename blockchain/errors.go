@@ -0,0 +1,71 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrInvalidSignature     = errors.New("could not verify transaction signature")
+	ErrInvalidValue         = errors.New("transaction value must be positive")
+	ErrInsufficientBalance  = errors.New("not enough balance in wallet")
+	ErrSenderMismatch       = errors.New("public key does not own the spent output")
+	ErrMissingInput         = errors.New("transaction spends an output that does not exist or is already spent")
+	ErrDoubleSpend          = errors.New("output is spent more than once")
+	ErrInsufficientFee      = errors.New("transaction fee is below the minimum relay fee")
+	ErrDuplicateTransaction = errors.New("transaction is already pending")
+	ErrMempoolFull          = errors.New("mempool is full and the transaction does not pay enough to replace others")
+	ErrInvalidAddress       = errors.New("invalid blockchain address")
+
+	ErrInvalidHeader       = errors.New("invalid block header")
+	ErrTransactionNotFound = errors.New("transaction not found in the chain")
+	ErrUnknownHeader       = errors.New("proof refers to a block header that is not tracked")
+	ErrInvalidProof        = errors.New("merkle branch does not lead to the block's merkle root")
+	ErrInvalidCoinbase     = errors.New("invalid mining reward transaction")
+	ErrInvalidStateRoot    = errors.New("state root does not match the unspent outputs")
+	ErrBlockTooLarge       = errors.New("block transactions exceed the block size limit")
+	ErrIncompatiblePeer    = errors.New("incompatible peer")
+	ErrBannedPeer          = errors.New("banned peer")
+	ErrInvalidBlock        = errors.New("invalid block")
+	ErrMinerRunning        = errors.New("miner is already running")
+	ErrStaleBlock          = errors.New("block does not extend the tip of the chain")
+)
+
+// TransactionError is returned when a transaction is rejected. Reason is one
+// of the Err* values above so callers can match it with errors.Is.
+type TransactionError struct {
+	Transaction *Transaction
+	Reason      error
+	Detail      string
+}
+
+func (e *TransactionError) Error() string {
+	if e.Detail == "" {
+		return e.Reason.Error()
+	}
+	return fmt.Sprintf("%v: %s", e.Reason, e.Detail)
+}
+
+func (e *TransactionError) Unwrap() error {
+	return e.Reason
+}
+
+// BlockError is returned when a block whose header checked out is rejected
+// for its transactions. It names the block, so the peer that served it can be
+// told apart from the one that announced it.
+type BlockError struct {
+	Block  *Block
+	Reason error
+}
+
+func (e *BlockError) Error() string {
+	return fmt.Sprintf("%v %d: %v", ErrInvalidBlock, e.Block.Height, e.Reason)
+}
+
+func (e *BlockError) Unwrap() []error {
+	return []error{ErrInvalidBlock, e.Reason}
+}
+
+func rejectTransaction(t *Transaction, reason error, format string, a ...any) *TransactionError {
+	return &TransactionError{Transaction: t, Reason: reason, Detail: fmt.Sprintf(format, a...)}
+}
@@ -0,0 +1,167 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+const (
+	PROTOCOL_VERSION     = 1
+	MIN_PROTOCOL_VERSION = 1
+
+	DEFAULT_NETWORK = "main"
+
+	// Services a node advertises in its handshake.
+	SERVICE_BLOCKS = 1 << 0 // serves headers and full blocks
+	SERVICE_PEERS  = 1 << 1 // exchanges peer addresses
+
+	REQUIRED_SERVICES = SERVICE_BLOCKS | SERVICE_PEERS
+)
+
+// VersionMessage opens a connection between two nodes. Each side sends its
+// own and refuses the other if it speaks an older protocol, belongs to another
+// network or lacks the services we rely on. Listen is the address the sender
+// accepts peer connections at.
+type VersionMessage struct {
+	Version  uint32   `json:"version"`
+	Network  string   `json:"network"`
+	From     string   `json:"from"`
+	Listen   string   `json:"listen"`
+	Height   uint64   `json:"height"`
+	TipHash  [32]byte `json:"tip_hash"`
+	Services uint64   `json:"services"`
+}
+
+// SetNetwork sets the network id peers must share with us.
+func (bc *Blockchain) SetNetwork(network string) {
+	bc.network = network
+}
+
+func (bc *Blockchain) versionMessage() *VersionMessage {
+	bc.mux.Lock()
+	last := bc.LastBlock()
+	bc.mux.Unlock()
+
+	return &VersionMessage{
+		Version:  PROTOCOL_VERSION,
+		Network:  bc.network,
+		From:     bc.peers.Self(),
+		Listen:   bc.listen,
+		Height:   last.Height,
+		TipHash:  last.Hash(),
+		Services: REQUIRED_SERVICES,
+	}
+}
+
+func (bc *Blockchain) checkVersion(v *VersionMessage) error {
+	switch {
+	case v.Version < MIN_PROTOCOL_VERSION:
+		return fmt.Errorf("%w: protocol version %d, need at least %d", ErrIncompatiblePeer, v.Version, MIN_PROTOCOL_VERSION)
+	case v.Network != bc.network:
+		return fmt.Errorf("%w: network %q, we are on %q", ErrIncompatiblePeer, v.Network, bc.network)
+	case v.Services&REQUIRED_SERVICES != REQUIRED_SERVICES:
+		return fmt.Errorf("%w: services %b, need %b", ErrIncompatiblePeer, v.Services, REQUIRED_SERVICES)
+	case v.From != "" && v.From == bc.peers.Self():
+		return fmt.Errorf("%w: connected to ourselves", ErrIncompatiblePeer)
+	}
+	return nil
+}
+
+// checkRemote refuses a peer that connected to us from remote if its host or
+// the address it claims in v is banned, or if that address is not at its
+// host. Scores and bans are kept under that address, so a peer must not pick
+// it freely.
+func (bc *Blockchain) checkRemote(v *VersionMessage, remote string) error {
+	switch {
+	case bc.BannedRemote(remote), bc.Banned(v.From):
+		return fmt.Errorf("%w: %s is banned", ErrBannedPeer, remote)
+	case v.From != "" && !sameHost(v.From, remote):
+		return fmt.Errorf("%w: %s claims to be %s", ErrIncompatiblePeer, remote, v.From)
+	}
+	return nil
+}
+
+// ReceiveVersion answers a handshake another node sent from the remote
+// address with our own version message, or refuses it.
+func (bc *Blockchain) ReceiveVersion(v *VersionMessage, remote string) (*VersionMessage, error) {
+	if err := bc.checkRemote(v, remote); err != nil {
+		return nil, err
+	}
+	if err := bc.checkVersion(v); err != nil {
+		return nil, err
+	}
+
+	if v.From != "" {
+		bc.peers.Add(v.From)
+		bc.peers.Seen(v.From)
+		bc.recordVersion(v.From, v)
+	}
+	return bc.versionMessage(), nil
+}
+
+// handshake exchanges version messages with peer before we start talking to
+// it. An error wrapping ErrIncompatiblePeer means either side refused.
+func (bc *Blockchain) handshake(peer string) error {
+	if bc.Banned(peer) {
+		return fmt.Errorf("%w: %s is banned", ErrBannedPeer, peer)
+	}
+	m, _ := json.Marshal(bc.versionMessage())
+
+	client := &http.Client{Timeout: PEER_TIMEOUT}
+	resp, err := client.Post(fmt.Sprintf("http://%s/version", peer), "application/json", bytes.NewBuffer(m))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		// A peer that banned us may take us back once the ban expires.
+		reason := ErrIncompatiblePeer
+		if resp.StatusCode == http.StatusForbidden {
+			reason = ErrBannedPeer
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%w: %s refused the handshake: %s %s", reason, peer, resp.Status, bytes.TrimSpace(body))
+	}
+
+	var v VersionMessage
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		bc.Misbehaving(peer, err)
+		return err
+	}
+	if err := bc.checkVersion(&v); err != nil {
+		return err
+	}
+
+	bc.recordVersion(peer, &v)
+	return nil
+}
+
+func (bc *Blockchain) recordVersion(peer string, v *VersionMessage) {
+	bc.muxNeighbors.Lock()
+	defer bc.muxNeighbors.Unlock()
+	bc.versions[peer] = v
+}
+
+// handshaken reports whether peer has completed a handshake with us.
+func (bc *Blockchain) handshaken(peer string) bool {
+	bc.muxNeighbors.Lock()
+	defer bc.muxNeighbors.Unlock()
+	_, ok := bc.versions[peer]
+	return ok
+}
+
+// PeerVersions returns the version messages of the peers we shook hands with.
+func (bc *Blockchain) PeerVersions() map[string]*VersionMessage {
+	bc.muxNeighbors.Lock()
+	defer bc.muxNeighbors.Unlock()
+
+	versions := make(map[string]*VersionMessage, len(bc.versions))
+	for peer, v := range bc.versions {
+		versions[peer] = v
+	}
+	return versions
+}
@@ -0,0 +1,287 @@
+package blockchain
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	MEMPOOL_MAX_SIZE = 5000000 // bytes of pending transactions
+	MEMPOOL_EXPIRY   = 24 * time.Hour
+)
+
+type mempoolEntry struct {
+	transaction *Transaction
+	size        int
+	added       time.Time
+	sequence    uint64
+}
+
+// Mempool holds the transactions waiting to be mined, keyed by transaction
+// id. It tracks which outputs the pending transactions spend and create, so
+// a new transaction can build on pending change but never spend an output
+// twice. When full, the transactions paying the lowest fee rate are evicted
+// first, and transactions pending longer than the expiry are dropped.
+type Mempool struct {
+	entries  map[[32]byte]*mempoolEntry
+	spent    map[OutPoint][32]byte
+	created  map[OutPoint]*TxOutput
+	size     int
+	maxSize  int
+	expiry   time.Duration
+	sequence uint64
+	mux      sync.Mutex
+}
+
+func NewMempool(maxSize int, expiry time.Duration) *Mempool {
+	return &Mempool{
+		entries: make(map[[32]byte]*mempoolEntry),
+		spent:   make(map[OutPoint][32]byte),
+		created: make(map[OutPoint]*TxOutput),
+		maxSize: maxSize,
+		expiry:  expiry,
+	}
+}
+
+// Add validates t on top of utxos and the pending transactions and adds it,
+// evicting cheaper transactions if the pool is full.
+func (mp *Mempool) Add(t *Transaction, utxos *UTXOSet) error {
+	mp.mux.Lock()
+	defer mp.mux.Unlock()
+
+	mp.expire(time.Now())
+
+	hash := t.Hash()
+	if _, ok := mp.entries[hash]; ok {
+		return rejectTransaction(t, ErrDuplicateTransaction, "%x", hash)
+	}
+
+	if minimum := t.MinimumFee(); t.Fee < minimum {
+		return rejectTransaction(t, ErrInsufficientFee, "pays %s, %s required", t.Fee, minimum)
+	}
+
+	for _, in := range t.Inputs {
+		if _, ok := mp.spent[in.PreviousOutput]; ok {
+			return rejectTransaction(t, ErrDoubleSpend, "%s is spent by a pending transaction", in.PreviousOutput)
+		}
+	}
+
+	lookup := func(op OutPoint) *TxOutput {
+		if out, ok := mp.created[op]; ok {
+			return out
+		}
+		return utxos.Get(op)
+	}
+	if err := VerifyTransaction(t, lookup); err != nil {
+		return err
+	}
+
+	size := t.Size()
+	if err := mp.makeRoom(t, size); err != nil {
+		return err
+	}
+
+	mp.sequence++
+	mp.entries[hash] = &mempoolEntry{transaction: t, size: size, added: time.Now(), sequence: mp.sequence}
+	mp.size += size
+	for _, in := range t.Inputs {
+		mp.spent[in.PreviousOutput] = hash
+	}
+	for i, out := range t.Outputs {
+		mp.created[OutPoint{TransactionHash: hash, Index: uint32(i)}] = out
+	}
+	return nil
+}
+
+// makeRoom evicts the transactions with the lowest fee rate, along with
+// those spending their outputs, until t fits. The pending transactions t
+// builds on are never evicted, as that would leave it spending outputs that
+// no longer exist. It fails if t pays no more than what would have to go.
+func (mp *Mempool) makeRoom(t *Transaction, size int) error {
+	if size > mp.maxSize {
+		return rejectTransaction(t, ErrMempoolFull, "%d bytes", size)
+	}
+
+	ancestors := mp.ancestors(t)
+	for mp.size+size > mp.maxSize {
+		var lowest *mempoolEntry
+		for hash, e := range mp.entries {
+			if ancestors[hash] {
+				continue
+			}
+			if lowest == nil || e.transaction.FeeRate() < lowest.transaction.FeeRate() {
+				lowest = e
+			}
+		}
+
+		if lowest == nil {
+			return rejectTransaction(t, ErrMempoolFull, "only the transactions it spends could be evicted")
+		}
+		if lowest.transaction.FeeRate() >= t.FeeRate() {
+			return rejectTransaction(t, ErrMempoolFull, "fee rate %.2f", t.FeeRate())
+		}
+
+		victim := lowest.transaction.Hash()
+		log.Printf("Mempool full: evicting %x", victim)
+		mp.removeWithDescendants(victim)
+	}
+	return nil
+}
+
+// ancestors returns the pending transactions t spends outputs of, directly or
+// through other pending transactions.
+func (mp *Mempool) ancestors(t *Transaction) map[[32]byte]bool {
+	ancestors := make(map[[32]byte]bool)
+	queue := []*Transaction{t}
+	for len(queue) > 0 {
+		tx := queue[0]
+		queue = queue[1:]
+		for _, in := range tx.Inputs {
+			parent := in.PreviousOutput.TransactionHash
+			if e, ok := mp.entries[parent]; ok && !ancestors[parent] {
+				ancestors[parent] = true
+				queue = append(queue, e.transaction)
+			}
+		}
+	}
+	return ancestors
+}
+
+// Remove drops the transactions of a block added to the chain, together with
+// any pending transaction that spends the same outputs and everything built
+// on those. Other pending transactions stay.
+func (mp *Mempool) Remove(transactions []*Transaction) {
+	mp.mux.Lock()
+	defer mp.mux.Unlock()
+
+	for _, t := range transactions {
+		mp.remove(t.Hash())
+		for _, in := range t.Inputs {
+			if conflict, ok := mp.spent[in.PreviousOutput]; ok {
+				mp.removeWithDescendants(conflict)
+			}
+		}
+	}
+}
+
+// Expire drops transactions pending for longer than the expiry.
+func (mp *Mempool) Expire(now time.Time) {
+	mp.mux.Lock()
+	defer mp.mux.Unlock()
+	mp.expire(now)
+}
+
+func (mp *Mempool) expire(now time.Time) {
+	for hash, e := range mp.entries {
+		if now.Sub(e.added) > mp.expiry {
+			log.Printf("Mempool: transaction %x expired", hash)
+			mp.removeWithDescendants(hash)
+		}
+	}
+}
+
+func (mp *Mempool) removeWithDescendants(hash [32]byte) {
+	e, ok := mp.entries[hash]
+	if !ok {
+		return
+	}
+	mp.remove(hash)
+	for i := range e.transaction.Outputs {
+		if child, ok := mp.spent[OutPoint{TransactionHash: hash, Index: uint32(i)}]; ok {
+			mp.removeWithDescendants(child)
+		}
+	}
+}
+
+func (mp *Mempool) remove(hash [32]byte) {
+	e, ok := mp.entries[hash]
+	if !ok {
+		return
+	}
+	delete(mp.entries, hash)
+	mp.size -= e.size
+	for _, in := range e.transaction.Inputs {
+		delete(mp.spent, in.PreviousOutput)
+	}
+	for i := range e.transaction.Outputs {
+		delete(mp.created, OutPoint{TransactionHash: hash, Index: uint32(i)})
+	}
+}
+
+// Clear empties the pool and returns what it held in arrival order.
+func (mp *Mempool) Clear() []*Transaction {
+	mp.mux.Lock()
+	defer mp.mux.Unlock()
+
+	transactions := mp.transactions()
+	mp.entries = make(map[[32]byte]*mempoolEntry)
+	mp.spent = make(map[OutPoint][32]byte)
+	mp.created = make(map[OutPoint]*TxOutput)
+	mp.size = 0
+	return transactions
+}
+
+// Transactions returns the pending transactions in arrival order, so parents
+// come before the transactions spending their outputs.
+func (mp *Mempool) Transactions() []*Transaction {
+	mp.mux.Lock()
+	defer mp.mux.Unlock()
+	return mp.transactions()
+}
+
+func (mp *Mempool) transactions() []*Transaction {
+	entries := make([]*mempoolEntry, 0, len(mp.entries))
+	for _, e := range mp.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].sequence < entries[j].sequence
+	})
+
+	transactions := make([]*Transaction, len(entries))
+	for i, e := range entries {
+		transactions[i] = e.transaction
+	}
+	return transactions
+}
+
+func (mp *Mempool) Get(hash [32]byte) *Transaction {
+	mp.mux.Lock()
+	defer mp.mux.Unlock()
+
+	if e, ok := mp.entries[hash]; ok {
+		return e.transaction
+	}
+	return nil
+}
+
+func (mp *Mempool) Len() int {
+	mp.mux.Lock()
+	defer mp.mux.Unlock()
+	return len(mp.entries)
+}
+
+// Spendable lists the outputs paying address that no pending transaction
+// spends: its unspent outputs in utxos, then outputs of pending transactions.
+func (mp *Mempool) Spendable(address string, utxos *UTXOSet) []*UTXO {
+	mp.mux.Lock()
+	defer mp.mux.Unlock()
+
+	spendable := []*UTXO{}
+	for _, u := range utxos.Unspent(address) {
+		if _, ok := mp.spent[u.OutPoint]; !ok {
+			spendable = append(spendable, u)
+		}
+	}
+
+	pending := []*UTXO{}
+	for op, out := range mp.created {
+		if _, ok := mp.spent[op]; !ok && out.Address == address {
+			pending = append(pending, &UTXO{OutPoint: op, Output: out})
+		}
+	}
+	sortUTXOs(pending)
+	return append(spendable, pending...)
+}
@@ -0,0 +1,93 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"errors"
+	"testing"
+	"time"
+)
+
+// spendAtRate pays the value of the output at op back to k, less a fee of at
+// least rate per byte.
+func spendAtRate(t *testing.T, k *testKey, op OutPoint, value Amount, rate Amount) *Transaction {
+	t.Helper()
+	// The size may grow with the fee, so the fee is raised until it covers it.
+	var fee Amount
+	for {
+		tx := k.pay(t, []OutPoint{op}, []*TxOutput{{Address: k.address, Value: value - fee}}, fee)
+		need := rate * Amount(tx.Size())
+		if fee >= need {
+			return tx
+		}
+		fee = need
+	}
+}
+
+// ancestry funds k and builds an unrelated transaction and a grandparent,
+// parent and child chain, the child paying the highest fee rate and the
+// unrelated transaction the lowest.
+func ancestry(t *testing.T, k *testKey) (utxos *UTXOSet, unrelated, grandparent, parent, child *Transaction) {
+	t.Helper()
+	utxos = NewUTXOSet()
+	funding := [2]OutPoint{
+		{TransactionHash: sha256.Sum256([]byte("unrelated"))},
+		{TransactionHash: sha256.Sum256([]byte("grandparent"))},
+	}
+	for _, op := range funding {
+		utxos.add(op, &TxOutput{Address: k.address, Value: COIN})
+	}
+
+	unrelated = spendAtRate(t, k, funding[0], COIN, 10)
+	grandparent = spendAtRate(t, k, funding[1], COIN, 12)
+	parent = spendAtRate(t, k, OutPoint{TransactionHash: grandparent.Hash()}, grandparent.Outputs[0].Value, 20)
+	child = spendAtRate(t, k, OutPoint{TransactionHash: parent.Hash()}, parent.Outputs[0].Value, 30)
+	return utxos, unrelated, grandparent, parent, child
+}
+
+func TestMempoolKeepsGrandparentOfNewTransaction(t *testing.T) {
+	k := newTestKey(t)
+	utxos, _, grandparent, parent, child := ancestry(t, k)
+
+	// Room for two transactions: the child only fits if the grandparent,
+	// the cheapest, goes, which would take the parent with it.
+	mp := NewMempool(grandparent.Size()+parent.Size(), time.Hour)
+	for _, tx := range []*Transaction{grandparent, parent} {
+		if err := mp.Add(tx, utxos); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := mp.Add(child, utxos); !errors.Is(err, ErrMempoolFull) {
+		t.Fatalf("child added with error %v, want %v", err, ErrMempoolFull)
+	}
+	if mp.Get(grandparent.Hash()) == nil || mp.Get(parent.Hash()) == nil {
+		t.Error("ancestors of the rejected child were evicted")
+	}
+	if mp.Get(child.Hash()) != nil {
+		t.Error("child was added without its inputs")
+	}
+}
+
+func TestMempoolEvictsOutsideAncestry(t *testing.T) {
+	k := newTestKey(t)
+	utxos, unrelated, grandparent, parent, child := ancestry(t, k)
+
+	mp := NewMempool(max(unrelated.Size(), child.Size())+grandparent.Size()+parent.Size(), time.Hour)
+	for _, tx := range []*Transaction{unrelated, grandparent, parent} {
+		if err := mp.Add(tx, utxos); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := mp.Add(child, utxos); err != nil {
+		t.Fatal(err)
+	}
+	if mp.Get(unrelated.Hash()) != nil {
+		t.Error("unrelated transaction was not evicted")
+	}
+	for _, tx := range []*Transaction{grandparent, parent, child} {
+		if mp.Get(tx.Hash()) == nil {
+			t.Errorf("transaction %x missing from the pool", tx.Hash())
+		}
+	}
+}
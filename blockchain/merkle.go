@@ -0,0 +1,127 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+)
+
+// MerkleRoot hashes leaves pairwise, level by level, until one hash is left.
+// A level with an odd number of hashes pairs its last hash with itself. The
+// root of no leaves is the zero hash.
+func MerkleRoot(leaves [][32]byte) [32]byte {
+	if len(leaves) == 0 {
+		return [32]byte{}
+	}
+
+	level := leaves
+	for len(level) > 1 {
+		level = merkleParents(level)
+	}
+	return level[0]
+}
+
+func merkleParents(level [][32]byte) [][32]byte {
+	parents := make([][32]byte, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		right := level[i]
+		if i+1 < len(level) {
+			right = level[i+1]
+		}
+		parents = append(parents, hashPair(level[i], right))
+	}
+	return parents
+}
+
+func hashPair(left [32]byte, right [32]byte) [32]byte {
+	var pair [64]byte
+	copy(pair[:32], left[:])
+	copy(pair[32:], right[:])
+	return sha256.Sum256(pair[:])
+}
+
+// TransactionsMerkleRoot commits to transactions through their witness
+// hashes. Keys and signatures are committed too, so a block body that hashes
+// to its header is exactly the body its miner built.
+func TransactionsMerkleRoot(transactions []*Transaction) [32]byte {
+	return MerkleRoot(transactionLeaves(transactions))
+}
+
+func transactionLeaves(transactions []*Transaction) [][32]byte {
+	leaves := make([][32]byte, len(transactions))
+	for i, t := range transactions {
+		leaves[i] = t.WitnessHash()
+	}
+	return leaves
+}
+
+// MerkleBranch returns the sibling hashes on the path from leaves[index] up
+// to the root, lowest level first.
+func MerkleBranch(leaves [][32]byte, index int) [][32]byte {
+	var branch [][32]byte
+	level := leaves
+	for len(level) > 1 {
+		sibling := index ^ 1
+		if sibling >= len(level) {
+			sibling = index
+		}
+		branch = append(branch, level[sibling])
+		level = merkleParents(level)
+		index /= 2
+	}
+	return branch
+}
+
+// MerkleRootFromBranch recomputes the root from a leaf at index and its
+// branch as returned by MerkleBranch.
+func MerkleRootFromBranch(leaf [32]byte, index int, branch [][32]byte) [32]byte {
+	h := leaf
+	for _, sibling := range branch {
+		if index%2 == 0 {
+			h = hashPair(h, sibling)
+		} else {
+			h = hashPair(sibling, h)
+		}
+		index /= 2
+	}
+	return h
+}
+
+// InclusionProof shows that the transaction with TransactionHash is committed
+// in the block with Header, without needing the other transactions. The
+// Merkle leaf is the witness hash of the full Transaction, which is carried
+// along to tie it to TransactionHash.
+type InclusionProof struct {
+	TransactionHash [32]byte    `json:"transaction_hash"`
+	Transaction     []byte      `json:"transaction"`
+	BlockHash       [32]byte    `json:"block_hash"`
+	Header          BlockHeader `json:"header"`
+	Index           int         `json:"index"`
+	Branch          [][32]byte  `json:"branch"`
+}
+
+// Verify checks the proof against headers a client has already validated,
+// keyed by block hash. The header must be one of them and the branch must
+// lead from the transaction to its Merkle root.
+func (p *InclusionProof) Verify(headers map[[32]byte]*BlockHeader) error {
+	header, ok := headers[p.BlockHash]
+	if !ok || header.Hash() != p.BlockHash || *header != p.Header {
+		return ErrUnknownHeader
+	}
+
+	t := new(Transaction)
+	if err := json.Unmarshal(p.Transaction, t); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidProof, err)
+	}
+	if t.Hash() != p.TransactionHash {
+		return fmt.Errorf("%w: transaction does not match its hash", ErrInvalidProof)
+	}
+
+	if p.Index < 0 || p.Index >= 1<<len(p.Branch) {
+		return ErrInvalidProof
+	}
+	if MerkleRootFromBranch(t.WitnessHash(), p.Index, p.Branch) != header.MerkleRoot {
+		return ErrInvalidProof
+	}
+	return nil
+}
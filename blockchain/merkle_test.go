@@ -0,0 +1,55 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestMerkleRootCommitsToSignatures(t *testing.T) {
+	k := newTestKey(t)
+	funding := OutPoint{TransactionHash: sha256.Sum256([]byte("funding"))}
+	tx := k.pay(t, []OutPoint{funding}, []*TxOutput{{Address: k.address, Value: COIN}}, 0)
+	resigned := k.pay(t, []OutPoint{funding}, []*TxOutput{{Address: k.address, Value: COIN}}, 0)
+
+	if tx.Hash() != resigned.Hash() {
+		t.Fatal("signatures changed the transaction id")
+	}
+	if TransactionsMerkleRoot([]*Transaction{tx}) == TransactionsMerkleRoot([]*Transaction{resigned}) {
+		t.Error("swapping the signature left the merkle root unchanged")
+	}
+}
+
+func TestInclusionProof(t *testing.T) {
+	bc := newTestChain(t, t.TempDir())
+	miner := newTestKey(t)
+	b := mineBlock(t, bc, miner.address)
+	headers := map[[32]byte]*BlockHeader{b.Hash(): &b.BlockHeader}
+
+	proof, err := bc.TransactionProof(b.Transactions[0].Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := proof.Verify(headers); err != nil {
+		t.Fatalf("valid proof rejected: %v", err)
+	}
+
+	// A proof of one transaction does not pass for another.
+	other := *proof
+	other.TransactionHash = sha256.Sum256([]byte("another transaction"))
+	if err := other.Verify(headers); !errors.Is(err, ErrInvalidProof) {
+		t.Errorf("proof verified for another transaction: %v", err)
+	}
+
+	// Nor does it pass with a signature the block does not commit to.
+	tx := *b.Transactions[0]
+	tx.Inputs = []*TxInput{{Signature: "forged"}}
+	other = *proof
+	if other.Transaction, err = json.Marshal(&tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Verify(headers); !errors.Is(err, ErrInvalidProof) {
+		t.Errorf("proof verified for a resigned transaction: %v", err)
+	}
+}
@@ -0,0 +1,293 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jvsena42/go_blockchain/utils"
+)
+
+const (
+	// Workers check for an abort, and count their hashes, every
+	// MINER_CHECK_INTERVAL nonces.
+	MINER_CHECK_INTERVAL = 1 << 12
+	// A background miner that could not assemble a block tries again after
+	// MINER_RETRY_INTERVAL.
+	MINER_RETRY_INTERVAL = 10 * time.Second
+)
+
+// MinerStatus reports what the background miner is doing.
+type MinerStatus struct {
+	Mining      bool    `json:"mining"`
+	Address     string  `json:"address"`
+	Workers     int     `json:"workers"`
+	BlocksFound uint64  `json:"blocks_found"`
+	Hashrate    float64 `json:"hashrate"`
+}
+
+// Miner searches for proof-of-work outside the chain lock. The nonce space is
+// split between its workers, and the search is abandoned as soon as the tip
+// or the pool changes, so the next attempt starts from a fresh template.
+type Miner struct {
+	bc      *Blockchain
+	workers int
+
+	// changed is closed, and replaced, when the tip or the pool changes, to
+	// abandon the templates being worked on.
+	changed chan struct{}
+
+	// stop is closed to end background mining, which closes done once it
+	// has; both are nil while the miner is idle.
+	stop    chan struct{}
+	done    chan struct{}
+	address string
+
+	blocks   atomic.Uint64
+	hashes   atomic.Uint64
+	hashrate float64
+	mux      sync.Mutex
+}
+
+func NewMiner(bc *Blockchain, workers int) *Miner {
+	m := &Miner{bc: bc, changed: make(chan struct{})}
+	m.SetWorkers(workers)
+	return m
+}
+
+// SetWorkers sets how many goroutines search for a nonce, one per CPU if
+// workers is not positive. It applies from the next template on.
+func (m *Miner) SetWorkers(workers int) {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.workers = workers
+}
+
+func (m *Miner) Workers() int {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return m.workers
+}
+
+// Start mines block after block paying address in the background, until
+// Stop is called.
+func (m *Miner) Start(address string) error {
+	if !utils.ValidAddress(address) {
+		return fmt.Errorf("%w: %q", ErrInvalidAddress, address)
+	}
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if m.stop != nil {
+		return ErrMinerRunning
+	}
+	m.stop = make(chan struct{})
+	m.done = make(chan struct{})
+	m.address = address
+	go m.run(address, m.stop, m.done)
+
+	log.Printf("Started mining to %s with %d workers", address, m.workers)
+	return nil
+}
+
+// Stop ends background mining and waits for the workers to return. It
+// reports whether the miner was running.
+func (m *Miner) Stop() bool {
+	m.mux.Lock()
+	stop, done := m.stop, m.done
+	m.stop, m.done = nil, nil
+	m.mux.Unlock()
+	if stop == nil {
+		return false
+	}
+
+	close(stop)
+	<-done
+	m.mux.Lock()
+	m.hashrate = 0
+	m.mux.Unlock()
+
+	log.Println("Stopped mining")
+	return true
+}
+
+func (m *Miner) run(address string, stop chan struct{}, done chan struct{}) {
+	defer close(done)
+	for {
+		if m.MineBlock(address, stop) != nil {
+			continue
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(MINER_RETRY_INTERVAL):
+		}
+	}
+}
+
+func (m *Miner) Status() *MinerStatus {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return &MinerStatus{
+		Mining:      m.stop != nil,
+		Address:     m.address,
+		Workers:     m.workers,
+		BlocksFound: m.blocks.Load(),
+		Hashrate:    m.hashrate,
+	}
+}
+
+// Interrupt abandons the templates being worked on.
+func (m *Miner) Interrupt() {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	close(m.changed)
+	m.changed = make(chan struct{})
+}
+
+// Hashrate is the number of hashes per second over the last template worked
+// on.
+func (m *Miner) Hashrate() float64 {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return m.hashrate
+}
+
+// Hashes is the number of hashes computed since the miner was created.
+func (m *Miner) Hashes() uint64 {
+	return m.hashes.Load()
+}
+
+// MineBlock mines a block paying address and connects it to our chain. When
+// the tip or the pool changes mid-search, it starts again on a new template.
+// It gives up and returns nil once stop is closed.
+func (m *Miner) MineBlock(address string, stop <-chan struct{}) *Block {
+	for {
+		// The channel is taken before the template is assembled so no change
+		// after it goes unnoticed.
+		m.mux.Lock()
+		abort := m.changed
+		workers := m.workers
+		m.mux.Unlock()
+
+		b, err := m.bc.NewBlockTemplate(address)
+		if err != nil {
+			log.Printf("ERROR: could not assemble block: %v", err)
+			return nil
+		}
+
+		start, hashes := time.Now(), m.Hashes()
+		header, found := m.solve(b.BlockHeader, workers, abort, stop)
+		m.mux.Lock()
+		m.hashrate = float64(m.Hashes()-hashes) / time.Since(start).Seconds()
+		m.mux.Unlock()
+
+		if !found {
+			select {
+			case <-stop:
+				return nil
+			default:
+				continue
+			}
+		}
+
+		b.BlockHeader = *header
+		connected, err := m.bc.connectMinedBlock(b)
+		if err != nil {
+			log.Printf("ERROR: mined an invalid block: %v", err)
+			return nil
+		}
+		if !connected {
+			continue
+		}
+		m.blocks.Add(1)
+		log.Printf("action=mining, status=success, height=%d, hashrate=%.0f H/s", b.Height, m.Hashrate())
+		return b
+	}
+}
+
+// solve searches for a nonce meeting the target of header. Each worker takes
+// its own slice of the nonce space and, should it run out, moves the
+// timestamp on and searches the slice again.
+func (m *Miner) solve(header BlockHeader, workers int, abort <-chan struct{}, stop <-chan struct{}) (*BlockHeader, bool) {
+	var target [32]byte
+	CompactToBig(header.Bits).FillBytes(target[:])
+
+	found := make(chan BlockHeader, workers)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+
+	span := math.MaxUint64 / uint64(workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(h BlockHeader, first uint64) {
+			defer wg.Done()
+			for {
+				if m.search(&h, first, first+span-1, &target, done) {
+					found <- h
+					return
+				}
+				select {
+				case <-done:
+					return
+				default:
+				}
+				h.TimeStamp = max(time.Now().UnixNano(), h.TimeStamp+1)
+			}
+		}(header, uint64(w)*span)
+	}
+
+	var h BlockHeader
+	ok := false
+	select {
+	case h = <-found:
+		ok = true
+	case <-abort:
+	case <-stop:
+	}
+	close(done)
+	wg.Wait()
+	return &h, ok
+}
+
+// search tries the nonces from first to last on h, stopping early once done
+// is closed. On success h holds the nonce found.
+func (m *Miner) search(h *BlockHeader, first uint64, last uint64, target *[32]byte, done <-chan struct{}) bool {
+	buf := h.Bytes()
+	nonce := buf[BLOCK_HEADER_SIZE-8:]
+
+	n := first
+	defer func() { m.hashes.Add((n - first) % MINER_CHECK_INTERVAL) }()
+	for ; ; n++ {
+		if (n-first)%MINER_CHECK_INTERVAL == 0 && n != first {
+			m.hashes.Add(MINER_CHECK_INTERVAL)
+			select {
+			case <-done:
+				return false
+			default:
+			}
+		}
+
+		binary.BigEndian.PutUint64(nonce, n)
+		hash := sha256.Sum256(buf)
+		if bytes.Compare(hash[:], target[:]) <= 0 {
+			h.Nonce = n
+			n++
+			return true
+		}
+		if n == last {
+			n++
+			return false
+		}
+	}
+}
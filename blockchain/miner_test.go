@@ -0,0 +1,88 @@
+package blockchain
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMinerSolvesTemplate(t *testing.T) {
+	bc := newTestChain(t, t.TempDir())
+	miner := newTestKey(t)
+
+	b := NewMiner(bc, 4).MineBlock(miner.address, make(chan struct{}))
+	if b == nil {
+		t.Fatal("no block mined")
+	}
+	if !HashMeetsTarget(b.Hash(), b.Bits) {
+		t.Errorf("block hash %x does not meet target %08x", b.Hash(), b.Bits)
+	}
+	if bc.LastBlock() != b {
+		t.Error("mined block not connected")
+	}
+	if got := bc.CalculateTotalAmount(miner.address); got != MINING_REWARD {
+		t.Errorf("miner holds %s, want the reward of %s", got, MINING_REWARD)
+	}
+}
+
+// unsolvable is a header no worker will find a nonce for within a test.
+func unsolvable(bc *Blockchain) BlockHeader {
+	h := bc.LastBlock().BlockHeader
+	h.Height++
+	h.PreviousHash = bc.LastBlock().Hash()
+	h.Bits = 0x03000001
+	return h
+}
+
+func TestMinerAbandonsStaleTemplate(t *testing.T) {
+	bc := newTestChain(t, t.TempDir())
+	m := NewMiner(bc, 2)
+	bc.miner = m
+
+	m.mux.Lock()
+	abort := m.changed
+	m.mux.Unlock()
+
+	result := make(chan bool)
+	go func() {
+		_, found := m.solve(unsolvable(bc), 2, abort, nil)
+		result <- found
+	}()
+
+	// A new tip interrupts the search.
+	time.Sleep(20 * time.Millisecond)
+	mineBlock(t, bc, newTestKey(t).address)
+	select {
+	case found := <-result:
+		if found {
+			t.Fatal("solved an unsolvable header")
+		}
+	case <-time.After(PEER_TIMEOUT):
+		t.Fatal("search not abandoned after the tip changed")
+	}
+	if m.Hashes() == 0 {
+		t.Error("no hashes counted")
+	}
+}
+
+func TestMinerStops(t *testing.T) {
+	bc := newTestChain(t, t.TempDir())
+	m := NewMiner(bc, 2)
+
+	stop := make(chan struct{})
+	result := make(chan bool)
+	go func() {
+		_, found := m.solve(unsolvable(bc), 2, make(chan struct{}), stop)
+		result <- found
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	close(stop)
+	select {
+	case found := <-result:
+		if found {
+			t.Fatal("solved an unsolvable header")
+		}
+	case <-time.After(PEER_TIMEOUT):
+		t.Fatal("search not stopped")
+	}
+}
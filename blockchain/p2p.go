@@ -0,0 +1,528 @@
+package blockchain
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	PING_INTERVAL = 30 * time.Second
+	// A connection that stays silent for PEER_IDLE_TIMEOUT, pings included,
+	// is closed.
+	PEER_IDLE_TIMEOUT = 3 * PING_INTERVAL
+	// Messages queued for a peer beyond PEER_SEND_QUEUE mean it does not keep
+	// up, and it is dropped.
+	PEER_SEND_QUEUE = 256
+)
+
+// PeerConn is a persistent TCP connection to a peer, identified by the HTTP
+// address the peer advertises. Blocks and transactions are announced over it
+// with inv messages and fetched with getdata, and the chain is synchronised
+// over it with getheaders.
+type PeerConn struct {
+	peer  string
+	conn  net.Conn
+	magic [4]byte
+	send  chan []byte
+	done  chan struct{}
+	once  sync.Once
+	bc    *Blockchain
+
+	// The requests of ours waiting for an answer: at most one getheaders,
+	// and the blocks asked for while synchronising, by hash.
+	headersReply chan []*BlockHeader
+	blockReplies map[[32]byte]chan *Block
+	muxHeaders   sync.Mutex
+	muxRequests  sync.Mutex
+}
+
+// ListenPeers accepts peer connections on listen and advertises advertise as
+// the address to reach them at.
+func (bc *Blockchain) ListenPeers(listen string, advertise string) error {
+	l, err := net.Listen("tcp", listen)
+	if err != nil {
+		return err
+	}
+	bc.listen = advertise
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				log.Printf("ERROR: peer listener stopped: %v", err)
+				return
+			}
+			go bc.servePeer(conn, "")
+		}
+	}()
+	return nil
+}
+
+// connectPeer opens a connection to peer unless there already is one, at the
+// address it gave in its handshake. That address must be at the host we
+// reached peer at, so a peer cannot have us dial anywhere else.
+func (bc *Blockchain) connectPeer(peer string) {
+	bc.muxNeighbors.Lock()
+	v, ok := bc.versions[peer]
+	bc.muxNeighbors.Unlock()
+	if !ok || v.Listen == "" || bc.connected(peer) {
+		return
+	}
+	host, _, err := net.SplitHostPort(peer)
+	listenHost, _, listenErr := net.SplitHostPort(v.Listen)
+	if err != nil || listenErr != nil || host != listenHost {
+		log.Printf("ERROR: %s listens for peers at %s, not at its own host", peer, v.Listen)
+		return
+	}
+
+	conn, err := net.DialTimeout("tcp", v.Listen, PEER_TIMEOUT)
+	if err != nil {
+		log.Printf("ERROR: could not connect to %s at %s: %v", peer, v.Listen, err)
+		return
+	}
+	go bc.servePeer(conn, peer)
+}
+
+// servePeer runs a connection: the version handshake, then messages until
+// either side closes it. peer is empty for a connection the peer opened; it
+// is known once the peer sends its version.
+func (bc *Blockchain) servePeer(conn net.Conn, peer string) {
+	pc := &PeerConn{
+		peer:  peer,
+		conn:  conn,
+		magic: networkMagic(bc.network),
+		send:  make(chan []byte, PEER_SEND_QUEUE),
+		done:  make(chan struct{}),
+		bc:    bc,
+
+		blockReplies: make(map[[32]byte]chan *Block),
+	}
+
+	v, err := pc.handshake()
+	if err != nil {
+		log.Printf("ERROR: peer connection with %s failed: %v", conn.RemoteAddr(), err)
+		bc.Misbehaving(pc.peer, err)
+		conn.Close()
+		return
+	}
+
+	bc.muxConns.Lock()
+	bc.conns[pc] = true
+	bc.muxConns.Unlock()
+	log.Printf("Connected to peer %s at %s", pc.peer, conn.RemoteAddr())
+
+	go pc.writeLoop()
+	go pc.pingLoop()
+	if bc.behind(v) {
+		go bc.ResolveConflicts()
+	}
+	pc.readLoop()
+}
+
+// handshake exchanges version and verack messages. Each side checks the
+// other's version as in the HTTP handshake, and returns the peer's.
+func (pc *PeerConn) handshake() (*VersionMessage, error) {
+	pc.conn.SetDeadline(time.Now().Add(PEER_TIMEOUT))
+	defer pc.conn.SetDeadline(time.Time{})
+
+	if _, err := pc.conn.Write(frameMessage(pc.magic, MSG_VERSION, encodeVersion(pc.bc.versionMessage()))); err != nil {
+		return nil, err
+	}
+
+	command, payload, err := readMessage(pc.conn, pc.magic)
+	if err != nil {
+		return nil, err
+	}
+	if command != MSG_VERSION {
+		return nil, fmt.Errorf("%w: expected version, got command %d", ErrMalformedMessage, command)
+	}
+	v, err := decodeVersion(payload)
+	if err != nil {
+		return nil, err
+	}
+	if pc.peer == "" {
+		// Until the peer's address is checked against the connection,
+		// nothing is charged to it.
+		if v.From == "" {
+			return nil, fmt.Errorf("%w: peer address %q", ErrIncompatiblePeer, v.From)
+		}
+		if err := pc.bc.checkRemote(v, pc.conn.RemoteAddr().String()); err != nil {
+			return nil, err
+		}
+		pc.peer = v.From
+	} else if pc.bc.Banned(pc.peer) {
+		return nil, fmt.Errorf("%w: %s is banned", ErrBannedPeer, pc.peer)
+	} else if v.From != pc.peer {
+		// We dialled the address the peer gave over HTTP; it must not
+		// answer as another.
+		return nil, fmt.Errorf("%w: %s claims to be %s", ErrIncompatiblePeer, pc.peer, v.From)
+	}
+	if err := pc.bc.checkVersion(v); err != nil {
+		return nil, err
+	}
+	pc.bc.recordVersion(pc.peer, v)
+
+	if _, err := pc.conn.Write(frameMessage(pc.magic, MSG_VERACK, nil)); err != nil {
+		return nil, err
+	}
+	if command, _, err = readMessage(pc.conn, pc.magic); err != nil {
+		return nil, err
+	}
+	if command != MSG_VERACK {
+		return nil, fmt.Errorf("%w: expected verack, got command %d", ErrMalformedMessage, command)
+	}
+	return v, nil
+}
+
+func (pc *PeerConn) readLoop() {
+	defer pc.close()
+
+	for {
+		pc.conn.SetReadDeadline(time.Now().Add(PEER_IDLE_TIMEOUT))
+		command, payload, err := readMessage(pc.conn, pc.magic)
+		if err != nil {
+			select {
+			case <-pc.done:
+			default:
+				log.Printf("Disconnected from peer %s: %v", pc.peer, err)
+				pc.bc.Misbehaving(pc.peer, err)
+			}
+			return
+		}
+
+		if err := pc.bc.handleMessage(pc, command, payload); err != nil {
+			log.Printf("ERROR: message %d from %s: %v", command, pc.peer, err)
+			pc.bc.Misbehaving(pc.peer, err)
+			if pc.bc.Banned(pc.peer) {
+				return
+			}
+		}
+	}
+}
+
+func (pc *PeerConn) writeLoop() {
+	defer pc.close()
+
+	for {
+		select {
+		case frame := <-pc.send:
+			pc.conn.SetWriteDeadline(time.Now().Add(PEER_TIMEOUT))
+			if _, err := pc.conn.Write(frame); err != nil {
+				return
+			}
+		case <-pc.done:
+			return
+		}
+	}
+}
+
+func (pc *PeerConn) pingLoop() {
+	ticker := time.NewTicker(PING_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			nonce := make([]byte, 8)
+			rand.Read(nonce)
+			pc.queue(MSG_PING, nonce)
+		case <-pc.done:
+			return
+		}
+	}
+}
+
+// queue sends a message without waiting. A peer whose queue is full is
+// dropped rather than allowed to hold us up.
+func (pc *PeerConn) queue(command byte, payload []byte) {
+	select {
+	case pc.send <- frameMessage(pc.magic, command, payload):
+	case <-pc.done:
+	default:
+		log.Printf("ERROR: peer %s is not keeping up, disconnecting", pc.peer)
+		pc.close()
+	}
+}
+
+func (pc *PeerConn) close() {
+	pc.once.Do(func() {
+		close(pc.done)
+		pc.conn.Close()
+
+		pc.bc.muxConns.Lock()
+		delete(pc.bc.conns, pc)
+		pc.bc.muxConns.Unlock()
+	})
+}
+
+func (bc *Blockchain) handleMessage(pc *PeerConn, command byte, payload []byte) error {
+	switch command {
+	case MSG_INV:
+		items, err := decodeInv(payload)
+		if err != nil {
+			return err
+		}
+		var wanted []InvItem
+		for _, item := range items {
+			if !bc.haveInv(item) {
+				wanted = append(wanted, item)
+			}
+		}
+		if len(wanted) > 0 {
+			pc.queue(MSG_GETDATA, encodeInv(wanted))
+		}
+
+	case MSG_GETDATA:
+		items, err := decodeInv(payload)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			switch item.Type {
+			case INV_BLOCK:
+				if b, err := bc.BlockByHash(item.Hash); err == nil {
+					pc.queue(MSG_BLOCK, b.Bytes())
+					continue
+				}
+			case INV_TX:
+				if t := bc.mempool.Get(item.Hash); t != nil {
+					pc.queue(MSG_TX, t.Bytes())
+					continue
+				}
+			}
+			pc.queue(MSG_NOTFOUND, encodeInv([]InvItem{item}))
+		}
+
+	case MSG_NOTFOUND:
+		items, err := decodeInv(payload)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			if item.Type == INV_BLOCK {
+				pc.replyBlock(item.Hash, nil)
+			}
+		}
+
+	case MSG_GETHEADERS:
+		locator, err := decodeLocator(payload)
+		if err != nil {
+			return err
+		}
+		pc.queue(MSG_HEADERS, encodeHeaders(bc.HeadersAfter(locator)))
+
+	case MSG_HEADERS:
+		headers, err := decodeHeaders(payload)
+		if err != nil {
+			return err
+		}
+		pc.replyHeaders(headers)
+
+	case MSG_BLOCK:
+		b, err := DecodeBlock(payload)
+		if err != nil {
+			return err
+		}
+		// A block we asked for while synchronising goes to the request,
+		// not through the relay.
+		if pc.replyBlock(b.Hash(), b) {
+			return nil
+		}
+		connected, err := bc.ReceiveBlock(b)
+		if err != nil {
+			return err
+		}
+		if connected {
+			bc.announce(InvItem{Type: INV_BLOCK, Hash: b.Hash()}, pc)
+		}
+
+	case MSG_TX:
+		t, err := DecodeTransaction(payload)
+		if err != nil {
+			return err
+		}
+		if err := bc.AddTransaction(t); err != nil {
+			return err
+		}
+		bc.announce(InvItem{Type: INV_TX, Hash: t.Hash()}, pc)
+
+	case MSG_PING:
+		pc.queue(MSG_PONG, payload)
+
+	case MSG_PONG:
+		// Any message keeps the connection alive.
+
+	case MSG_VERSION, MSG_VERACK:
+		return fmt.Errorf("%w: repeated handshake", ErrMalformedMessage)
+
+	default:
+		// Commands from newer protocol versions are ignored.
+	}
+	return nil
+}
+
+// getHeaders asks the peer for the headers following the first block of
+// locator it has, and waits for them.
+func (pc *PeerConn) getHeaders(locator [][32]byte) ([]*BlockHeader, error) {
+	pc.muxHeaders.Lock()
+	defer pc.muxHeaders.Unlock()
+
+	reply := make(chan []*BlockHeader, 1)
+	pc.muxRequests.Lock()
+	pc.headersReply = reply
+	pc.muxRequests.Unlock()
+	defer func() {
+		pc.muxRequests.Lock()
+		pc.headersReply = nil
+		pc.muxRequests.Unlock()
+	}()
+
+	pc.queue(MSG_GETHEADERS, encodeLocator(locator))
+	select {
+	case headers := <-reply:
+		return headers, nil
+	case <-pc.done:
+		return nil, fmt.Errorf("connection with %s closed", pc.peer)
+	case <-time.After(PEER_TIMEOUT):
+		return nil, fmt.Errorf("%w: %s sent no headers", os.ErrDeadlineExceeded, pc.peer)
+	}
+}
+
+// getBlock asks the peer for the body of the block with header, and checks
+// that its transactions match the Merkle root.
+func (pc *PeerConn) getBlock(header *BlockHeader) (*Block, error) {
+	hash := header.Hash()
+	reply := make(chan *Block, 1)
+	pc.muxRequests.Lock()
+	pc.blockReplies[hash] = reply
+	pc.muxRequests.Unlock()
+	defer func() {
+		pc.muxRequests.Lock()
+		if pc.blockReplies[hash] == reply {
+			delete(pc.blockReplies, hash)
+		}
+		pc.muxRequests.Unlock()
+	}()
+
+	pc.queue(MSG_GETDATA, encodeInv([]InvItem{{Type: INV_BLOCK, Hash: hash}}))
+	select {
+	case b := <-reply:
+		if b == nil {
+			return nil, fmt.Errorf("%s does not have block %x", pc.peer, hash)
+		}
+		if b.MerkleRoot != TransactionsMerkleRoot(b.Transactions) {
+			return nil, fmt.Errorf("%w: transactions of block %x do not match its merkle root", ErrInvalidHeader, hash)
+		}
+		return b, nil
+	case <-pc.done:
+		return nil, fmt.Errorf("connection with %s closed", pc.peer)
+	case <-time.After(PEER_TIMEOUT):
+		return nil, fmt.Errorf("%w: %s did not send block %x", os.ErrDeadlineExceeded, pc.peer, hash)
+	}
+}
+
+// replyHeaders hands headers to the getheaders waiting for them. Headers no
+// one asked for are dropped.
+func (pc *PeerConn) replyHeaders(headers []*BlockHeader) {
+	pc.muxRequests.Lock()
+	reply := pc.headersReply
+	pc.headersReply = nil
+	pc.muxRequests.Unlock()
+
+	if reply != nil {
+		reply <- headers
+	}
+}
+
+// replyBlock hands b, or nil if the peer does not have it, to the request
+// for the block with hash, reporting whether there was one.
+func (pc *PeerConn) replyBlock(hash [32]byte, b *Block) bool {
+	pc.muxRequests.Lock()
+	reply, ok := pc.blockReplies[hash]
+	delete(pc.blockReplies, hash)
+	pc.muxRequests.Unlock()
+
+	if ok {
+		reply <- b
+	}
+	return ok
+}
+
+func (bc *Blockchain) haveInv(item InvItem) bool {
+	switch item.Type {
+	case INV_BLOCK:
+		_, ok := bc.store.HeightOf(item.Hash)
+		return ok
+	case INV_TX:
+		return bc.mempool.Get(item.Hash) != nil
+	}
+	return true
+}
+
+// announce sends item to every connected peer but from, the peer it came
+// from. Peers ask for what they do not have yet.
+func (bc *Blockchain) announce(item InvItem, from *PeerConn) {
+	payload := encodeInv([]InvItem{item})
+	for _, pc := range bc.peerConns() {
+		if pc != from {
+			pc.queue(MSG_INV, payload)
+		}
+	}
+}
+
+func (bc *Blockchain) peerConns() []*PeerConn {
+	bc.muxConns.Lock()
+	defer bc.muxConns.Unlock()
+
+	conns := make([]*PeerConn, 0, len(bc.conns))
+	for pc := range bc.conns {
+		conns = append(conns, pc)
+	}
+	return conns
+}
+
+func (bc *Blockchain) connected(peer string) bool {
+	return bc.peerConn(peer) != nil
+}
+
+// peerConn returns a connection with peer, or nil if there is none.
+func (bc *Blockchain) peerConn(peer string) *PeerConn {
+	for _, pc := range bc.peerConns() {
+		if pc.peer == peer {
+			return pc
+		}
+	}
+	return nil
+}
+
+// behind reports whether a peer that sent v in its handshake may have blocks
+// we lack.
+func (bc *Blockchain) behind(v *VersionMessage) bool {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	last := bc.LastBlock()
+	return v.TipHash != last.Hash() && v.Height >= last.Height
+}
+
+// Connections lists the peers we hold a connection with.
+func (bc *Blockchain) Connections() []string {
+	var peers []string
+	for _, pc := range bc.peerConns() {
+		peers = append(peers, pc.peer)
+	}
+	return peers
+}
+
+// closeConns drops every connection with peer.
+func (bc *Blockchain) closeConns(peer string) {
+	for _, pc := range bc.peerConns() {
+		if pc.peer == peer {
+			pc.close()
+		}
+	}
+}
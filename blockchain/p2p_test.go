@@ -0,0 +1,116 @@
+package blockchain
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// connectChains opens a peer connection from b to a over loopback and waits
+// for the handshake, returning the address b knows a by.
+func connectChains(t *testing.T, a, b *Blockchain) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		if conn, err := l.Accept(); err == nil {
+			a.servePeer(conn, "")
+		}
+	}()
+
+	a.UsePeers(l.Addr().String(), nil)
+	b.UsePeers("127.0.0.1:1", nil)
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer := l.Addr().String()
+	go b.servePeer(conn, peer)
+	t.Cleanup(func() { b.closeConns(peer) })
+
+	for deadline := time.Now().Add(PEER_TIMEOUT); !b.connected(peer); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("handshake did not complete")
+		}
+	}
+	return peer
+}
+
+func TestSyncOverPeerConnection(t *testing.T) {
+	a := newTestChain(t, t.TempDir())
+	b := newTestChain(t, t.TempDir())
+	miner := newTestKey(t)
+	for i := 0; i < 3; i++ {
+		mineBlock(t, a, miner.address)
+	}
+	peer := connectChains(t, a, b)
+
+	changed, err := b.syncFrom(peer)
+	if err != nil || !changed {
+		t.Fatalf("sync changed the chain %v, error %v", changed, err)
+	}
+	if b.LastBlock().Hash() != a.LastBlock().Hash() {
+		t.Fatalf("synchronised to height %d, peer is at %d", b.LastBlock().Height, a.LastBlock().Height)
+	}
+
+	// A block the peer does not have is answered with notfound rather than
+	// left to time out, which would be charged to the peer.
+	unknown := a.LastBlock().BlockHeader
+	unknown.Nonce++
+	start := time.Now()
+	if _, err := b.peerConn(peer).getBlock(&unknown); err == nil || Penalty(err) != 0 {
+		t.Errorf("unknown block fetched with error %v", err)
+	}
+	if time.Since(start) >= PEER_TIMEOUT {
+		t.Error("request for an unknown block timed out")
+	}
+}
+
+func TestOutboundPeerAnswersAsDialled(t *testing.T) {
+	a := newTestChain(t, t.TempDir())
+	b := newTestChain(t, t.TempDir())
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		if conn, err := l.Accept(); err == nil {
+			a.servePeer(conn, "")
+		}
+	}()
+	a.UsePeers(l.Addr().String(), nil)
+	b.UsePeers("127.0.0.1:1", nil)
+
+	// b dials what it takes for another peer, and a answers as itself.
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.servePeer(conn, "127.0.0.1:2")
+	if b.connected("127.0.0.1:2") || b.handshaken("127.0.0.1:2") {
+		t.Error("peer answering under another address was accepted")
+	}
+}
+
+func TestConnectPeerStaysOnPeerHost(t *testing.T) {
+	b := newTestChain(t, t.TempDir())
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	// A peer reached over HTTP at one host may not point us at another.
+	b.recordVersion("10.0.0.1:5000", &VersionMessage{Listen: l.Addr().String()})
+	b.connectPeer("10.0.0.1:5000")
+
+	l.(*net.TCPListener).SetDeadline(time.Now().Add(100 * time.Millisecond))
+	if conn, err := l.Accept(); err == nil {
+		conn.Close()
+		t.Error("dialled a listen address at another host")
+	}
+}
@@ -0,0 +1,411 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	TARGET_OUTBOUND_PEERS = 8
+	// At most MAX_SHARED_PEERS addresses are sent in one exchange, the most
+	// recently seen first.
+	MAX_SHARED_PEERS = 100
+	// A peer that failed to answer more than MAX_PEER_FAILURES times in a row
+	// is forgotten.
+	MAX_PEER_FAILURES = 3
+	PEER_TIMEOUT      = 5 * time.Second
+)
+
+type PeerInfo struct {
+	Address     string    `json:"address"`
+	LastSeen    time.Time `json:"last_seen"`
+	Failures    int       `json:"failures"`
+	Score       int       `json:"score"`
+	BannedUntil time.Time `json:"banned_until"`
+}
+
+func (p *PeerInfo) banned(now time.Time) bool {
+	return now.Before(p.BannedUntil)
+}
+
+// PeerTable holds every peer address a node has heard of, from its seeds and
+// from address exchanges with other peers.
+type PeerTable struct {
+	self  string
+	known map[string]*PeerInfo
+	mux   sync.Mutex
+}
+
+func NewPeerTable(peers []*PeerInfo) *PeerTable {
+	pt := &PeerTable{known: make(map[string]*PeerInfo, len(peers))}
+	for _, p := range peers {
+		pt.known[p.Address] = p
+	}
+	return pt
+}
+
+// SetSelf records the address other peers reach us at, so it is never added
+// to the table.
+func (pt *PeerTable) SetSelf(address string) {
+	pt.mux.Lock()
+	defer pt.mux.Unlock()
+	pt.self = address
+	delete(pt.known, address)
+}
+
+func (pt *PeerTable) Self() string {
+	pt.mux.Lock()
+	defer pt.mux.Unlock()
+	return pt.self
+}
+
+// Add learns a host:port address, reporting whether it was new.
+func (pt *PeerTable) Add(address string) bool {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return false
+	}
+
+	pt.mux.Lock()
+	defer pt.mux.Unlock()
+
+	if _, ok := pt.known[address]; ok || address == pt.self {
+		return false
+	}
+	pt.known[address] = &PeerInfo{Address: address}
+	return true
+}
+
+func (pt *PeerTable) Seen(address string) {
+	pt.mux.Lock()
+	defer pt.mux.Unlock()
+
+	if p, ok := pt.known[address]; ok {
+		p.LastSeen = time.Now()
+		p.Failures = 0
+	}
+}
+
+func (pt *PeerTable) Failed(address string) {
+	pt.mux.Lock()
+	defer pt.mux.Unlock()
+
+	if p, ok := pt.known[address]; ok {
+		p.Failures++
+		if p.Failures > MAX_PEER_FAILURES && !p.banned(time.Now()) {
+			delete(pt.known, address)
+		}
+	}
+}
+
+// Remove forgets address, a peer we refuse to talk to.
+func (pt *PeerTable) Remove(address string) {
+	pt.mux.Lock()
+	defer pt.mux.Unlock()
+	delete(pt.known, address)
+}
+
+// Misbehaved adds penalty to the score of address, learning it if needed, and
+// bans it for BAN_DURATION once the score reaches BAN_SCORE, reporting whether
+// it did. The score starts over when a ban expires.
+func (pt *PeerTable) Misbehaved(address string, penalty int) bool {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return false
+	}
+
+	pt.mux.Lock()
+	defer pt.mux.Unlock()
+
+	if address == pt.self {
+		return false
+	}
+	p, ok := pt.known[address]
+	if !ok {
+		p = &PeerInfo{Address: address}
+		pt.known[address] = p
+	}
+
+	now := time.Now()
+	if !p.BannedUntil.IsZero() && !p.banned(now) {
+		p.Score = 0
+		p.BannedUntil = time.Time{}
+	}
+	p.Score += penalty
+	if p.Score >= BAN_SCORE && !p.banned(now) {
+		p.BannedUntil = now.Add(BAN_DURATION)
+		return true
+	}
+	return false
+}
+
+// Ban refuses address until the given time, whatever its score.
+func (pt *PeerTable) Ban(address string, until time.Time) bool {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return false
+	}
+
+	pt.mux.Lock()
+	defer pt.mux.Unlock()
+
+	p, ok := pt.known[address]
+	if !ok {
+		p = &PeerInfo{Address: address}
+		pt.known[address] = p
+	}
+	p.BannedUntil = until
+	return true
+}
+
+// Unban lifts the ban on address and clears its score, reporting whether it
+// was banned.
+func (pt *PeerTable) Unban(address string) bool {
+	pt.mux.Lock()
+	defer pt.mux.Unlock()
+
+	p, ok := pt.known[address]
+	if !ok || !p.banned(time.Now()) {
+		return false
+	}
+	p.Score = 0
+	p.BannedUntil = time.Time{}
+	return true
+}
+
+func (pt *PeerTable) Banned(address string) bool {
+	pt.mux.Lock()
+	defer pt.mux.Unlock()
+
+	p, ok := pt.known[address]
+	return ok && p.banned(time.Now())
+}
+
+// BannedHost reports whether any peer at the IP host is banned. A peer only
+// proves its host by connecting from it, not its port, so an inbound peer is
+// refused if any address at its host is. Loopback is exempt: nodes sharing a
+// machine are told apart by port, and banned one address at a time.
+func (pt *PeerTable) BannedHost(host string) bool {
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() {
+		return false
+	}
+
+	pt.mux.Lock()
+	defer pt.mux.Unlock()
+
+	now := time.Now()
+	for address, p := range pt.known {
+		h, _, err := net.SplitHostPort(address)
+		if err == nil && p.banned(now) && (h == host || ip.Equal(net.ParseIP(h))) {
+			return true
+		}
+	}
+	return false
+}
+
+// Bans returns the peers currently banned.
+func (pt *PeerTable) Bans() []*PeerInfo {
+	now := time.Now()
+	bans := []*PeerInfo{}
+	for _, p := range pt.Peers() {
+		if p.banned(now) {
+			bans = append(bans, p)
+		}
+	}
+	return bans
+}
+
+// Addresses returns up to limit known addresses that are not banned, most
+// recently seen first.
+func (pt *PeerTable) Addresses(limit int) []string {
+	now := time.Now()
+	peers := pt.Peers()
+	addresses := make([]string, 0, min(limit, len(peers)))
+	for _, p := range peers {
+		if len(addresses) == limit {
+			break
+		}
+		if !p.banned(now) {
+			addresses = append(addresses, p.Address)
+		}
+	}
+	return addresses
+}
+
+// Candidates returns the known addresses not in exclude and not banned, in
+// random order, to pick new outbound peers from.
+func (pt *PeerTable) Candidates(exclude []string) []string {
+	skip := make(map[string]bool, len(exclude))
+	for _, address := range exclude {
+		skip[address] = true
+	}
+
+	now := time.Now()
+	pt.mux.Lock()
+	candidates := make([]string, 0, len(pt.known))
+	for address, p := range pt.known {
+		if !skip[address] && !p.banned(now) {
+			candidates = append(candidates, address)
+		}
+	}
+	pt.mux.Unlock()
+
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+	return candidates
+}
+
+// Peers returns a copy of the table, most recently seen first.
+func (pt *PeerTable) Peers() []*PeerInfo {
+	pt.mux.Lock()
+	peers := make([]*PeerInfo, 0, len(pt.known))
+	for _, p := range pt.known {
+		c := *p
+		peers = append(peers, &c)
+	}
+	pt.mux.Unlock()
+
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].LastSeen.After(peers[j].LastSeen)
+	})
+	return peers
+}
+
+// PeersMessage is exchanged by peers: From is the sender's own address, if it
+// accepts connections, and Peers the addresses it knows.
+type PeersMessage struct {
+	From  string   `json:"from"`
+	Peers []string `json:"peers"`
+}
+
+// UsePeers sets the address we advertise to peers and adds the seed peers to
+// start from.
+func (bc *Blockchain) UsePeers(self string, seeds []string) {
+	bc.peers.SetSelf(self)
+	for _, seed := range seeds {
+		bc.peers.Add(seed)
+	}
+}
+
+// ReceivePeers learns the addresses in msg, including the sender's own if it
+// shook hands with us and sent msg from that host, and answers with the
+// addresses we know. remote is the address the request came from.
+func (bc *Blockchain) ReceivePeers(msg *PeersMessage, remote string) *PeersMessage {
+	if from := bc.InboundPeer(msg.From, remote); from != "" && bc.handshaken(from) {
+		bc.peers.Add(msg.From)
+		bc.peers.Seen(msg.From)
+	}
+	for _, address := range msg.Peers {
+		bc.peers.Add(address)
+	}
+	return &PeersMessage{From: bc.peers.Self(), Peers: bc.peers.Addresses(MAX_SHARED_PEERS)}
+}
+
+func (bc *Blockchain) Peers() []*PeerInfo {
+	return bc.peers.Peers()
+}
+
+// Neighbors returns the outbound peers blocks and transactions are sent to.
+func (bc *Blockchain) Neighbors() []string {
+	bc.muxNeighbors.Lock()
+	defer bc.muxNeighbors.Unlock()
+	return append([]string{}, bc.neighbors...)
+}
+
+// SyncNeighbors keeps up to TARGET_OUTBOUND_PEERS outbound peers. Current
+// peers are kept while they answer an address exchange, and new ones are
+// picked from the peer table to replace those that do not, once they complete
+// a handshake. Blocks and transactions travel over a connection kept open to
+// each of them.
+func (bc *Blockchain) SyncNeighbors() {
+	var neighbors []string
+	for _, n := range bc.Neighbors() {
+		if !bc.Banned(n) && bc.exchangePeers(n) {
+			neighbors = append(neighbors, n)
+		}
+	}
+	for _, candidate := range bc.peers.Candidates(neighbors) {
+		if len(neighbors) >= TARGET_OUTBOUND_PEERS {
+			break
+		}
+		if err := bc.handshake(candidate); err != nil {
+			log.Printf("ERROR: handshake with %s failed: %v", candidate, err)
+			if errors.Is(err, ErrIncompatiblePeer) {
+				bc.peers.Remove(candidate)
+			} else {
+				bc.peers.Failed(candidate)
+			}
+			continue
+		}
+		if bc.exchangePeers(candidate) {
+			neighbors = append(neighbors, candidate)
+		}
+	}
+
+	bc.muxNeighbors.Lock()
+	bc.neighbors = neighbors
+	bc.muxNeighbors.Unlock()
+
+	for _, n := range neighbors {
+		bc.connectPeer(n)
+	}
+
+	bc.savePeers()
+
+	if len(neighbors) > 0 {
+		log.Println("This node's neighbors are", neighbors)
+	} else {
+		log.Println("This node could not find neighbors", neighbors)
+	}
+}
+
+// savePeers writes the peer table, bans included, to the store. The neighbor
+// lock keeps two saves from racing on the same file.
+func (bc *Blockchain) savePeers() {
+	bc.muxNeighbors.Lock()
+	defer bc.muxNeighbors.Unlock()
+
+	if err := bc.store.SavePeers(bc.peers.Peers()); err != nil {
+		log.Printf("ERROR: could not save peers: %v", err)
+	}
+}
+
+// exchangePeers sends our addresses to peer and learns its own.
+func (bc *Blockchain) exchangePeers(peer string) bool {
+	m, _ := json.Marshal(&PeersMessage{From: bc.peers.Self(), Peers: bc.peers.Addresses(MAX_SHARED_PEERS)})
+
+	client := &http.Client{Timeout: PEER_TIMEOUT}
+	resp, err := client.Post(fmt.Sprintf("http://%s/peers", peer), "application/json", bytes.NewBuffer(m))
+	if err != nil {
+		bc.peers.Failed(peer)
+		bc.Misbehaving(peer, err)
+		return false
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		bc.peers.Failed(peer)
+		return false
+	}
+
+	var msg PeersMessage
+	if err := json.NewDecoder(resp.Body).Decode(&msg); err != nil {
+		bc.peers.Failed(peer)
+		bc.Misbehaving(peer, err)
+		return false
+	}
+
+	bc.peers.Seen(peer)
+	for _, address := range msg.Peers {
+		bc.peers.Add(address)
+	}
+	return true
+}
@@ -0,0 +1,145 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// servePeers answers handshakes and address exchanges for bc the way a node
+// does.
+func servePeers(t *testing.T, bc *Blockchain) string {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
+		var v VersionMessage
+		if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		ours, err := bc.ReceiveVersion(&v, r.RemoteAddr)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(ours)
+	})
+	mux.HandleFunc("/peers", func(w http.ResponseWriter, r *http.Request) {
+		var msg PeersMessage
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(bc.ReceivePeers(&msg, r.RemoteAddr))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return strings.TrimPrefix(srv.URL, "http://")
+}
+
+func TestExchangePeers(t *testing.T) {
+	a := newTestChain(t, t.TempDir())
+	b := newTestChain(t, t.TempDir())
+	addr := servePeers(t, b)
+	a.UsePeers("127.0.0.1:5000", []string{addr})
+	b.UsePeers(addr, []string{"10.0.0.2:5000"})
+
+	if err := a.handshake(addr); err != nil {
+		t.Fatal(err)
+	}
+	if !a.exchangePeers(addr) {
+		t.Fatal("exchange with a serving peer failed")
+	}
+
+	if got := a.peers.Addresses(MAX_SHARED_PEERS); !slices.Contains(got, "10.0.0.2:5000") {
+		t.Errorf("a knows %v, missing the peer b shared", got)
+	}
+	if got := b.peers.Addresses(MAX_SHARED_PEERS); !slices.Contains(got, "127.0.0.1:5000") {
+		t.Errorf("b knows %v, missing the sender's own address", got)
+	}
+	for _, p := range a.Peers() {
+		if p.Address == addr && p.LastSeen.IsZero() {
+			t.Error("answering peer not marked as seen")
+		}
+	}
+
+	// Peers never learn their own address back.
+	if slices.Contains(b.peers.Addresses(MAX_SHARED_PEERS), addr) {
+		t.Error("b learned its own address")
+	}
+}
+
+func TestPeerTableForgetsFailingPeers(t *testing.T) {
+	pt := NewPeerTable(nil)
+	pt.SetSelf("10.0.0.1:5000")
+	if pt.Add("10.0.0.1:5000") {
+		t.Error("own address added")
+	}
+	if pt.Add("not an address") {
+		t.Error("address without a port added")
+	}
+	if !pt.Add("10.0.0.2:5000") || pt.Add("10.0.0.2:5000") {
+		t.Fatal("peer not added exactly once")
+	}
+
+	for i := 0; i < MAX_PEER_FAILURES; i++ {
+		pt.Failed("10.0.0.2:5000")
+	}
+	if len(pt.Peers()) != 1 {
+		t.Fatalf("peer forgotten after %d failures", MAX_PEER_FAILURES)
+	}
+	pt.Seen("10.0.0.2:5000")
+	for i := 0; i <= MAX_PEER_FAILURES; i++ {
+		pt.Failed("10.0.0.2:5000")
+	}
+	if len(pt.Peers()) != 0 {
+		t.Error("peer kept after failing more than MAX_PEER_FAILURES times in a row")
+	}
+}
+
+func TestHandshakeRefusesIncompatiblePeers(t *testing.T) {
+	b := newTestChain(t, t.TempDir())
+	addr := servePeers(t, b)
+	b.UsePeers(addr, nil)
+
+	for name, tweak := range map[string]func(a *Blockchain){
+		"network":   func(a *Blockchain) { a.SetNetwork("test") },
+		"ourselves": func(a *Blockchain) { a.UsePeers(addr, nil) },
+	} {
+		a := newTestChain(t, t.TempDir())
+		a.UsePeers("127.0.0.1:5000", nil)
+		tweak(a)
+		if err := a.handshake(addr); !errors.Is(err, ErrIncompatiblePeer) {
+			t.Errorf("%s: handshake returned %v, want ErrIncompatiblePeer", name, err)
+		}
+		if a.handshaken(addr) {
+			t.Errorf("%s: refused peer recorded as handshaken", name)
+		}
+	}
+
+	for name, v := range map[string]*VersionMessage{
+		"old protocol": {Version: MIN_PROTOCOL_VERSION - 1, Network: DEFAULT_NETWORK, Services: REQUIRED_SERVICES},
+		"services":     {Version: PROTOCOL_VERSION, Network: DEFAULT_NETWORK, Services: SERVICE_PEERS},
+	} {
+		v.From = "10.0.0.3:5000"
+		if _, err := b.ReceiveVersion(v, "10.0.0.3:41000"); !errors.Is(err, ErrIncompatiblePeer) {
+			t.Errorf("%s: accepted with %v", name, err)
+		}
+	}
+	if b.handshaken("10.0.0.3:5000") {
+		t.Error("refused peer recorded as handshaken")
+	}
+
+	// A refused candidate is forgotten rather than retried.
+	a := newTestChain(t, t.TempDir())
+	a.SetNetwork("test")
+	a.UsePeers("127.0.0.1:5000", []string{addr})
+	a.SyncNeighbors()
+	if len(a.Neighbors()) != 0 || len(a.Peers()) != 0 {
+		t.Errorf("incompatible peer kept: neighbors %v, peers %d", a.Neighbors(), len(a.Peers()))
+	}
+}
@@ -0,0 +1,84 @@
+package blockchain
+
+import (
+	"fmt"
+	"log"
+)
+
+// ReceiveBlock handles a block pushed by a peer. A block extending our tip is
+// validated and connected on its own, and reported as new so the caller
+// relays it; a block we already have is not, so every block is relayed once.
+// Only when the parent is unknown, or the block makes a side branch heavier
+// than our chain, is the whole chain synchronised from the neighbors.
+func (bc *Blockchain) ReceiveBlock(b *Block) (bool, error) {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+
+	hash := b.Hash()
+	if _, ok := bc.store.HeightOf(hash); ok {
+		return false, nil
+	}
+
+	parentHeight, ok := bc.store.HeightOf(b.PreviousHash)
+	if !ok {
+		log.Printf("Block %x has an unknown parent, synchronising the chain", hash)
+		go bc.ResolveConflicts()
+		return false, nil
+	}
+
+	if parentHeight != len(bc.Chain)-1 {
+		branchWork := ChainWork(bc.Chain[:parentHeight+1])
+		branchWork.Add(branchWork, b.Work())
+		if branchWork.Cmp(ChainWork(bc.Chain)) > 0 {
+			log.Printf("Block %x makes a heavier branch, synchronising the chain", hash)
+			go bc.ResolveConflicts()
+		}
+		return false, nil
+	}
+
+	if err := bc.connectTip(b); err != nil {
+		return false, err
+	}
+
+	log.Printf("Connected block %d (%x) from a peer", b.Height, hash)
+	return true, nil
+}
+
+// connectMinedBlock connects a block we mined and announces it. It reports
+// false, and leaves the chain alone, when our tip moved on since the block's
+// template was assembled.
+func (bc *Blockchain) connectMinedBlock(b *Block) (bool, error) {
+	bc.mux.Lock()
+	if b.PreviousHash != bc.LastBlock().Hash() {
+		bc.mux.Unlock()
+		return false, nil
+	}
+	err := bc.connectTip(b)
+	bc.mux.Unlock()
+	if err != nil {
+		return false, err
+	}
+
+	bc.announce(InvItem{Type: INV_BLOCK, Hash: b.Hash()}, nil)
+	return true, nil
+}
+
+// connectTip validates b, whose parent is our tip, and appends it. bc.mux
+// must be held.
+func (bc *Blockchain) connectTip(b *Block) error {
+	if err := CheckHeader(&b.BlockHeader, &bc.LastBlock().BlockHeader, NextBits(bc.Chain)); err != nil {
+		return err
+	}
+	if b.MerkleRoot != TransactionsMerkleRoot(b.Transactions) {
+		return fmt.Errorf("%w: merkle root does not match the transactions", ErrInvalidHeader)
+	}
+	if err := bc.appendBlock(b); err != nil {
+		return err
+	}
+	return nil
+}
+
+// RelayBlock announces a block received from a peer to our other peers.
+func (bc *Blockchain) RelayBlock(b *Block) {
+	bc.announce(InvItem{Type: INV_BLOCK, Hash: b.Hash()}, nil)
+}
@@ -0,0 +1,281 @@
+package blockchain
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	STORE_BLOCKS_FILE = "blocks.dat"
+	STORE_POOL_FILE   = "pool.json"
+	STORE_PEERS_FILE  = "peers.json"
+
+	// Every record in the block log is prefixed by the payload length and a
+	// CRC32 of the payload, both big endian.
+	STORE_RECORD_HEADER_SIZE = 8
+)
+
+var ErrBlockNotFound = errors.New("block not found")
+
+// Store keeps the chain in an append-only log of length-prefixed, checksummed
+// block records. The index by hash and height is rebuilt from the log when the
+// store is opened. A record torn by a crash mid-write fails its checksum and is
+// cut off, so blocks written before it are never affected.
+type Store struct {
+	dir     string
+	file    *os.File
+	size    int64
+	offsets []int64
+	heights map[[32]byte]int
+	mux     sync.Mutex
+}
+
+func OpenStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, STORE_BLOCKS_FILE), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{dir: dir, file: f, heights: make(map[[32]byte]int)}
+	if err := s.load(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Store) load() error {
+	var offset int64
+	for {
+		b, n, err := s.readRecord(offset)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("WARNING: discarding block log after offset %d: %v", offset, err)
+			if err := s.file.Truncate(offset); err != nil {
+				return err
+			}
+			if err := s.file.Sync(); err != nil {
+				return err
+			}
+			break
+		}
+		s.heights[b.Hash()] = len(s.offsets)
+		s.offsets = append(s.offsets, offset)
+		offset += n
+	}
+	s.size = offset
+	return nil
+}
+
+func (s *Store) readRecord(offset int64) (*Block, int64, error) {
+	header := make([]byte, STORE_RECORD_HEADER_SIZE)
+	if _, err := s.file.ReadAt(header, offset); err != nil {
+		if err == io.EOF && s.isEnd(offset) {
+			return nil, 0, io.EOF
+		}
+		return nil, 0, fmt.Errorf("short record header: %w", err)
+	}
+
+	length := binary.BigEndian.Uint32(header[:4])
+	checksum := binary.BigEndian.Uint32(header[4:])
+
+	payload := make([]byte, length)
+	if _, err := s.file.ReadAt(payload, offset+STORE_RECORD_HEADER_SIZE); err != nil {
+		return nil, 0, fmt.Errorf("short record payload: %w", err)
+	}
+	if crc32.ChecksumIEEE(payload) != checksum {
+		return nil, 0, errors.New("record checksum mismatch")
+	}
+
+	b := new(Block)
+	if err := json.Unmarshal(payload, b); err != nil {
+		return nil, 0, err
+	}
+	return b, STORE_RECORD_HEADER_SIZE + int64(length), nil
+}
+
+func (s *Store) isEnd(offset int64) bool {
+	fi, err := s.file.Stat()
+	return err == nil && fi.Size() == offset
+}
+
+// Height returns the number of blocks in the store.
+func (s *Store) Height() int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return len(s.offsets)
+}
+
+func (s *Store) Append(b *Block) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	payload, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+
+	record := make([]byte, STORE_RECORD_HEADER_SIZE+len(payload))
+	binary.BigEndian.PutUint32(record[:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	copy(record[STORE_RECORD_HEADER_SIZE:], payload)
+
+	// A record that is not fully written is cut off again, so the next one
+	// follows the last complete record.
+	if _, err := s.file.WriteAt(record, s.size); err != nil {
+		s.file.Truncate(s.size)
+		return err
+	}
+	if err := s.file.Sync(); err != nil {
+		s.file.Truncate(s.size)
+		return err
+	}
+
+	s.heights[b.Hash()] = len(s.offsets)
+	s.offsets = append(s.offsets, s.size)
+	s.size += int64(len(record))
+	return nil
+}
+
+// Truncate drops every block at or above height.
+func (s *Store) Truncate(height int) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if height >= len(s.offsets) {
+		return nil
+	}
+
+	size := s.offsets[height]
+	if err := s.file.Truncate(size); err != nil {
+		return err
+	}
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+
+	for h, i := range s.heights {
+		if i >= height {
+			delete(s.heights, h)
+		}
+	}
+	s.offsets = s.offsets[:height]
+	s.size = size
+	return nil
+}
+
+func (s *Store) BlockByHeight(height int) (*Block, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if height < 0 || height >= len(s.offsets) {
+		return nil, ErrBlockNotFound
+	}
+	b, _, err := s.readRecord(s.offsets[height])
+	return b, err
+}
+
+// HeightOf returns the height of the block with the given hash.
+func (s *Store) HeightOf(hash [32]byte) (int, bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	height, ok := s.heights[hash]
+	return height, ok
+}
+
+func (s *Store) Blocks() ([]*Block, error) {
+	height := s.Height()
+	blocks := make([]*Block, 0, height)
+	for i := 0; i < height; i++ {
+		b, err := s.BlockByHeight(i)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, b)
+	}
+	return blocks, nil
+}
+
+// SavePool writes the transaction pool to a temporary file and renames it over
+// the previous snapshot, so a crash leaves either the old or the new pool.
+func (s *Store) SavePool(transactions []*Transaction) error {
+	return s.saveJSON(STORE_POOL_FILE, transactions)
+}
+
+func (s *Store) LoadPool() ([]*Transaction, error) {
+	transactions := []*Transaction{}
+	if err := s.loadJSON(STORE_POOL_FILE, &transactions); err != nil {
+		return nil, err
+	}
+	return transactions, nil
+}
+
+// SavePeers writes the peer table the same way as the pool.
+func (s *Store) SavePeers(peers []*PeerInfo) error {
+	return s.saveJSON(STORE_PEERS_FILE, peers)
+}
+
+func (s *Store) LoadPeers() ([]*PeerInfo, error) {
+	peers := []*PeerInfo{}
+	if err := s.loadJSON(STORE_PEERS_FILE, &peers); err != nil {
+		return nil, err
+	}
+	return peers, nil
+}
+
+func (s *Store) saveJSON(name string, v any) error {
+	m, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(s.dir, name)
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(m); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// loadJSON decodes the named file into v, leaving v untouched if the file
+// does not exist yet.
+func (s *Store) loadJSON(name string, v any) error {
+	m, err := os.ReadFile(filepath.Join(s.dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(m, v)
+}
+
+func (s *Store) Close() error {
+	return s.file.Close()
+}
@@ -0,0 +1,56 @@
+package blockchain
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStoreDiscardsTornRecord(t *testing.T) {
+	dir := t.TempDir()
+	bc := newTestChain(t, dir)
+	miner := newTestKey(t)
+	for i := 0; i < 3; i++ {
+		mineBlock(t, bc, miner.address)
+	}
+	bc.store.Close()
+
+	// A crash halfway through writing the last block leaves part of it.
+	path := filepath.Join(dir, STORE_BLOCKS_FILE)
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, fi.Size()-10); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := OpenStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if got := store.Height(); got != 3 {
+		t.Fatalf("reopened store holds %d blocks, want 3", got)
+	}
+	for height, b := range bc.Chain[:3] {
+		stored, err := store.BlockByHeight(height)
+		if err != nil || stored.Hash() != b.Hash() {
+			t.Fatalf("block %d not recovered: %v", height, err)
+		}
+	}
+
+	// The torn record is gone, so the next block follows the last complete one.
+	if err := store.Append(bc.Chain[3]); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+	if store, err = OpenStore(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if height, ok := store.HeightOf(bc.Chain[3].Hash()); !ok || height != 3 {
+		t.Fatalf("block appended after recovery at height %d, %v", height, ok)
+	}
+}
@@ -0,0 +1,255 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"sync"
+)
+
+const (
+	// A block locator lists the hashes of the last LOCATOR_DENSE_BLOCKS blocks,
+	// then doubles the step back towards the genesis block.
+	LOCATOR_DENSE_BLOCKS = 10
+
+	MAX_PARALLEL_BLOCK_DOWNLOADS = 8
+)
+
+// Locator describes our chain to a peer in a logarithmic number of hashes,
+// newest first and always ending with the genesis block, so the peer can find
+// the last block we have in common.
+func (bc *Blockchain) Locator() [][32]byte {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+
+	var locator [][32]byte
+	step := 1
+	for height := len(bc.Chain) - 1; height > 0; height -= step {
+		locator = append(locator, bc.Chain[height].Hash())
+		if len(locator) >= LOCATOR_DENSE_BLOCKS {
+			step *= 2
+		}
+	}
+	return append(locator, bc.Chain[0].Hash())
+}
+
+// BlockByHash returns a block of our chain.
+func (bc *Blockchain) BlockByHash(hash [32]byte) (*Block, error) {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+
+	height, ok := bc.store.HeightOf(hash)
+	if !ok {
+		return nil, ErrBlockNotFound
+	}
+	return bc.Chain[height], nil
+}
+
+// HeadersAfter returns up to MAX_HEADERS_PER_REQUEST headers following the
+// first block of locator found in our chain.
+func (bc *Blockchain) HeadersAfter(locator [][32]byte) []*BlockHeader {
+	from := 1
+	for _, hash := range locator {
+		if height, ok := bc.store.HeightOf(hash); ok {
+			from = height + 1
+			break
+		}
+	}
+	return bc.Headers(from)
+}
+
+// ResolveConflicts synchronises with every neighbor we hold a connection
+// with, headers first: it sends our locator in a getheaders message,
+// validates the headers the neighbor has past the common block and, if they
+// carry more work than our blocks past it, downloads only the missing block
+// bodies with getdata, spread over all neighbors. A neighbor that sends
+// invalid headers or blocks is charged for it. It reports whether our chain
+// changed.
+func (bc *Blockchain) ResolveConflicts() bool {
+	if !bc.syncing.CompareAndSwap(false, true) {
+		return false
+	}
+	defer bc.syncing.Store(false)
+
+	replaced := false
+	for _, n := range bc.Neighbors() {
+		changed, err := bc.syncFrom(n)
+		if err != nil {
+			log.Printf("ERROR: could not synchronise with %s: %v", n, err)
+			bc.Misbehaving(n, err)
+			continue
+		}
+		replaced = replaced || changed
+	}
+
+	if replaced {
+		log.Println("Conflics solved! Blockchain was replaced")
+	} else {
+		log.Println("Conflics solved! Blockchain was NOT replaced")
+	}
+	return replaced
+}
+
+func (bc *Blockchain) syncFrom(peer string) (bool, error) {
+	pc := bc.peerConn(peer)
+	if pc == nil {
+		return false, fmt.Errorf("not connected to %s", peer)
+	}
+
+	headers, err := pc.getHeaders(bc.Locator())
+	if err != nil || len(headers) == 0 {
+		return false, err
+	}
+
+	fork, err := bc.checkHeaders(headers, 0)
+	if err != nil {
+		return false, err
+	}
+
+	// A full page means the peer may have more. All headers are validated
+	// before any body is fetched.
+	for len(headers)%MAX_HEADERS_PER_REQUEST == 0 {
+		more, err := pc.getHeaders([][32]byte{headers[len(headers)-1].Hash()})
+		if err != nil {
+			return false, err
+		}
+		if len(more) == 0 {
+			break
+		}
+		checked := len(headers)
+		headers = append(headers, more...)
+		if _, err := bc.checkHeaders(headers, checked); err != nil {
+			return false, err
+		}
+	}
+
+	if !bc.heavierThanOurs(fork, headers) {
+		return false, nil
+	}
+
+	blocks, sources, err := bc.downloadBlocks(peer, headers)
+	if err != nil {
+		return false, err
+	}
+
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+
+	// The chain may have moved while the bodies were downloading.
+	if fork > len(bc.Chain) || bc.Chain[fork-1].Hash() != blocks[0].PreviousHash || !bc.heavierThanOursLocked(fork, headers) {
+		return false, nil
+	}
+	if err := bc.reorganize(fork, blocks); err != nil {
+		// The headers checked out, so an invalid block is down to the peer
+		// that served its body, which need not be the one that announced it.
+		var blockErr *BlockError
+		if errors.As(err, &blockErr) {
+			source := sources[int(blockErr.Block.Height)-fork]
+			bc.Misbehaving(source, err)
+			return false, fmt.Errorf("%s served an invalid body: %v", source, err)
+		}
+		return false, err
+	}
+	log.Printf("Synchronised %d blocks from %s, tip at height %d", len(blocks), peer, bc.LastBlock().Height)
+	return true, nil
+}
+
+// checkHeaders validates headers[from:], which must follow one another, on
+// top of the block of our chain the first header connects to. The headers
+// before from have been checked already and only serve to compute the target.
+// It returns the height of the first header, where the peer's chain forks
+// from ours.
+func (bc *Blockchain) checkHeaders(headers []*BlockHeader, from int) (int, error) {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+
+	parent, ok := bc.store.HeightOf(headers[0].PreviousHash)
+	if !ok {
+		return 0, fmt.Errorf("%w: headers do not connect to our chain", ErrInvalidHeader)
+	}
+	fork := parent + 1
+
+	header := func(i int) *BlockHeader {
+		if i < fork {
+			return &bc.Chain[i].BlockHeader
+		}
+		return headers[i-fork]
+	}
+	for i := from; i < len(headers); i++ {
+		if err := CheckHeader(headers[i], header(fork+i-1), nextBits(fork+i, header)); err != nil {
+			return 0, err
+		}
+	}
+	return fork, nil
+}
+
+func (bc *Blockchain) heavierThanOurs(fork int, headers []*BlockHeader) bool {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	return bc.heavierThanOursLocked(fork, headers)
+}
+
+// heavierThanOursLocked compares the work of headers with that of our blocks
+// from fork on; the blocks below are shared.
+func (bc *Blockchain) heavierThanOursLocked(fork int, headers []*BlockHeader) bool {
+	theirs := new(big.Int)
+	for _, h := range headers {
+		theirs.Add(theirs, h.Work())
+	}
+	return theirs.Cmp(ChainWork(bc.Chain[fork:])) > 0
+}
+
+// downloadBlocks fetches the bodies of headers in parallel. Each block is
+// requested from the neighbors in turn, starting at a different one per
+// block, with peer, which announced the headers, as the last resort. It also
+// returns the peer each block came from.
+func (bc *Blockchain) downloadBlocks(peer string, headers []*BlockHeader) ([]*Block, []string, error) {
+	peers := append(bc.Neighbors(), peer)
+	blocks := make([]*Block, len(headers))
+	sources := make([]string, len(headers))
+	errs := make([]error, len(headers))
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < MAX_PARALLEL_BLOCK_DOWNLOADS && w < len(headers); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				blocks[i], sources[i], errs[i] = bc.fetchBlockFromAny(peers, i, headers[i])
+			}
+		}()
+	}
+	for i := range headers {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return blocks, sources, nil
+}
+
+// fetchBlockFromAny charges each peer for a bad answer itself, so the error
+// it returns does not blame the peer that announced the headers.
+func (bc *Blockchain) fetchBlockFromAny(peers []string, start int, header *BlockHeader) (*Block, string, error) {
+	err := fmt.Errorf("not connected to any peer")
+	for k := range peers {
+		peer := peers[(start+k)%len(peers)]
+		pc := bc.peerConn(peer)
+		if pc == nil {
+			continue
+		}
+		var b *Block
+		if b, err = pc.getBlock(header); err == nil {
+			return b, peer, nil
+		}
+		bc.Misbehaving(peer, err)
+	}
+	return nil, "", fmt.Errorf("no peer served block %x: %v", header.Hash(), err)
+}
@@ -0,0 +1,88 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+
+	"github.com/jvsena42/go_blockchain/utils"
+)
+
+// BlockTemplate is the next block for an external miner to work on, as hex
+// where it is binary. Header is the encoded header with a zero nonce: the
+// nonce is its last 8 bytes, and the timestamp may be moved on once the nonce
+// space is exhausted. The solved block is Header followed by the uvarint
+// count of Transactions and their Data, coinbase first.
+type BlockTemplate struct {
+	Version      uint32                 `json:"version"`
+	Height       uint64                 `json:"height"`
+	PreviousHash string                 `json:"previous_hash"`
+	MerkleRoot   string                 `json:"merkle_root"`
+	StateRoot    string                 `json:"state_root"`
+	TimeStamp    int64                  `json:"time_stamp"`
+	Bits         uint32                 `json:"bits"`
+	Target       string                 `json:"target"`
+	Header       string                 `json:"header"`
+	Transactions []*TemplateTransaction `json:"transactions"`
+}
+
+type TemplateTransaction struct {
+	Hash string `json:"hash"`
+	Data string `json:"data"`
+	Fee  Amount `json:"fee"`
+	Size int    `json:"size"`
+}
+
+// BlockTemplate assembles the block our own miner would work on, with the
+// reward paid to address instead.
+func (bc *Blockchain) BlockTemplate(address string) (*BlockTemplate, error) {
+	if !utils.ValidAddress(address) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidAddress, address)
+	}
+	b, err := bc.NewBlockTemplate(address)
+	if err != nil {
+		return nil, err
+	}
+
+	var target [32]byte
+	CompactToBig(b.Bits).FillBytes(target[:])
+	tmpl := &BlockTemplate{
+		Version:      b.Version,
+		Height:       b.Height,
+		PreviousHash: hex.EncodeToString(b.PreviousHash[:]),
+		MerkleRoot:   hex.EncodeToString(b.MerkleRoot[:]),
+		StateRoot:    hex.EncodeToString(b.StateRoot[:]),
+		TimeStamp:    b.TimeStamp,
+		Bits:         b.Bits,
+		Target:       hex.EncodeToString(target[:]),
+		Header:       hex.EncodeToString(b.BlockHeader.Bytes()),
+	}
+	for _, t := range b.Transactions {
+		hash := t.Hash()
+		tmpl.Transactions = append(tmpl.Transactions, &TemplateTransaction{
+			Hash: hex.EncodeToString(hash[:]),
+			Data: hex.EncodeToString(t.Bytes()),
+			Fee:  t.Fee,
+			Size: t.Size(),
+		})
+	}
+	return tmpl, nil
+}
+
+// SubmitBlock connects a block solved by an external miner and announces it
+// to our peers. A block that no longer extends our tip is refused as stale.
+func (bc *Blockchain) SubmitBlock(b *Block) error {
+	if !bc.ValidProof(&b.BlockHeader) {
+		return fmt.Errorf("%w: hash does not meet target", ErrInvalidHeader)
+	}
+	connected, err := bc.connectMinedBlock(b)
+	if err != nil {
+		return err
+	}
+	if !connected {
+		return ErrStaleBlock
+	}
+
+	log.Printf("Connected submitted block %d (%x)", b.Height, b.Hash())
+	return nil
+}
@@ -1,79 +1,151 @@
 package blockchain
 
 import (
-	"encoding/json"
+	"crypto/sha256"
 	"fmt"
 	"strings"
 )
 
+// OutPoint names an output of an earlier transaction.
+type OutPoint struct {
+	TransactionHash [32]byte `json:"transaction_hash"`
+	Index           uint32   `json:"index"`
+}
+
+func (op OutPoint) String() string {
+	return fmt.Sprintf("%x:%d", op.TransactionHash, op.Index)
+}
+
+// TxInput spends PreviousOutput. PublicKey must hash to the address that
+// output pays, and Signature is that key's signature of the transaction hash.
+type TxInput struct {
+	PreviousOutput OutPoint `json:"previous_output"`
+	PublicKey      string   `json:"public_key"`
+	Signature      string   `json:"signature"`
+}
+
+type TxOutput struct {
+	Address string `json:"address"`
+	Value   Amount `json:"value"`
+}
+
+// Transaction spends unspent outputs of earlier transactions and creates new
+// ones, so a payment can have several recipients and return change. What the
+// inputs hold beyond the outputs is the Fee, collected by the miner. A
+// transaction without inputs is a block reward; it records the height of its
+// block so no two rewards hash alike.
 type Transaction struct {
-	SenderAddress    string
-	RecipientAddress string
-	Value            float32
+	Inputs         []*TxInput  `json:"inputs"`
+	Outputs        []*TxOutput `json:"outputs"`
+	Fee            Amount      `json:"fee"`
+	CoinbaseHeight uint64      `json:"coinbase_height"`
+}
+
+func (t *Transaction) IsCoinbase() bool {
+	return len(t.Inputs) == 0
 }
 
 func (t *Transaction) Print() {
 	fmt.Printf("%s\n", strings.Repeat("-", 40))
-	fmt.Printf("sender_blockchain_address:\t%s\n", t.SenderAddress)
-	fmt.Printf("recipient_blockchain_address:\t%s\n", t.RecipientAddress)
-	fmt.Printf("value:\t\t\t\t%1f\n", t.Value)
-}
-
-func (t *Transaction) MarshalJson() ([]byte, error) {
-	return json.Marshal(struct {
-		SenderAddress    string  `json:"sender_blockchain_address"`
-		RecipientAddress string  `json:"recipient_blockchain_address"`
-		Value            float32 `json:"value"`
-	}{
-		SenderAddress:    t.SenderAddress,
-		RecipientAddress: t.RecipientAddress,
-		Value:            t.Value,
-	})
-}
-
-func (t *Transaction) UnmarshalJson(data []byte) error {
-	v := struct {
-		SenderAddress    *string  `json:"sender_blockchain_address"`
-		RecipientAddress *string  `json:"recipient_blockchain_address"`
-		Value            *float32 `json:"value"`
-	}{
-		SenderAddress:    &t.SenderAddress,
-		RecipientAddress: &t.RecipientAddress,
-		Value:            &t.Value,
+	fmt.Printf("transaction_hash:\t%x\n", t.Hash())
+	if t.IsCoinbase() {
+		fmt.Printf("coinbase_height:\t%d\n", t.CoinbaseHeight)
 	}
-
-	if err := json.Unmarshal(data, &v); err != nil {
-		return err
+	for _, in := range t.Inputs {
+		fmt.Printf("input:\t\t\t%s\n", in.PreviousOutput)
+	}
+	for _, out := range t.Outputs {
+		fmt.Printf("output:\t\t\t%s %s\n", out.Address, out.Value)
+	}
+	if t.Fee != 0 {
+		fmt.Printf("fee:\t\t\t%s\n", t.Fee)
 	}
+}
+
+// Hash identifies the transaction by the payload its sender signed.
+func (t *Transaction) Hash() [32]byte {
+	return sha256.Sum256(t.SigningBytes())
+}
 
-	return nil
+// WitnessHash hashes the full encoding, keys and signatures included. Blocks
+// commit to their transactions through it.
+func (t *Transaction) WitnessHash() [32]byte {
+	return sha256.Sum256(t.Bytes())
 }
 
-func NewTransaction(sender string, recipient string, value float32) *Transaction {
+// Size is the length of the binary encoding of the transaction, signatures
+// included. Fees and block space are measured in it.
+func (t *Transaction) Size() int {
+	return len(t.Bytes())
+}
 
+// MinimumFee is the fee a transaction of t's size has to pay to be relayed.
+func (t *Transaction) MinimumFee() Amount {
+	return Amount(t.Size()) * MIN_RELAY_FEE_RATE
+}
+
+// FeeRate is the fee paid per byte.
+func (t *Transaction) FeeRate() float64 {
+	return float64(t.Fee) / float64(t.Size())
+}
+
+// OutputValue sums the outputs, each of which must be positive.
+func (t *Transaction) OutputValue() (Amount, error) {
+	var total Amount
+	for _, out := range t.Outputs {
+		if out.Value <= 0 {
+			return 0, rejectTransaction(t, ErrInvalidValue, "output of %s", out.Value)
+		}
+		if total+out.Value < total {
+			return 0, rejectTransaction(t, ErrInvalidValue, "outputs overflow")
+		}
+		total += out.Value
+	}
+	return total, nil
+}
+
+func NewTransaction(inputs []*TxInput, outputs []*TxOutput, fee Amount) *Transaction {
+	return &Transaction{
+		Inputs:  inputs,
+		Outputs: outputs,
+		Fee:     fee,
+	}
+}
+
+// NewCoinbaseTransaction pays the block reward at height to address.
+func NewCoinbaseTransaction(address string, value Amount, height uint64) *Transaction {
 	return &Transaction{
-		SenderAddress:    sender,
-		RecipientAddress: recipient,
-		Value:            value,
+		Inputs:         []*TxInput{},
+		Outputs:        []*TxOutput{{Address: address, Value: value}},
+		CoinbaseHeight: height,
 	}
 }
 
 type TransactionRequest struct {
-	SenderBlockchainAddress    *string  `json:"sender_blockchain_address"`
-	RecipientBlockchainAddress *string  `json:"recipient_blockchain_address"`
-	SenderPublicKey            *string  `json:"sender_public_key"`
-	Value                      *float32 `json:"value"`
-	Signature                  *string  `json:"signature"`
+	Inputs  []*TxInput  `json:"inputs"`
+	Outputs []*TxOutput `json:"outputs"`
+	Fee     *Amount     `json:"fee"`
 }
 
 func (tr *TransactionRequest) Valid() bool {
-	if tr.SenderBlockchainAddress == nil ||
-		tr.RecipientBlockchainAddress == nil ||
-		tr.SenderPublicKey == nil ||
-		tr.Value == nil ||
-		tr.Signature == nil {
+	if len(tr.Inputs) == 0 || len(tr.Outputs) == 0 || tr.Fee == nil {
 		return false
 	}
 
+	for _, in := range tr.Inputs {
+		if in == nil {
+			return false
+		}
+	}
+	for _, out := range tr.Outputs {
+		if out == nil {
+			return false
+		}
+	}
+
 	return true
 }
+
+func (tr *TransactionRequest) Transaction() *Transaction {
+	return NewTransaction(tr.Inputs, tr.Outputs, *tr.Fee)
+}
@@ -0,0 +1,334 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+
+	"github.com/jvsena42/go_blockchain/utils"
+)
+
+// UTXO is an unspent output together with the outpoint naming it.
+type UTXO struct {
+	OutPoint OutPoint  `json:"outpoint"`
+	Output   *TxOutput `json:"output"`
+}
+
+// UTXOSet holds the outputs that are unspent at the tip of a chain. It is
+// updated block by block: connecting a block spends its inputs and adds its
+// outputs, and the outputs it spent are kept so the block can be disconnected
+// again when the chain is reorganised. Balances are kept per address as
+// outputs come and go, so looking one up does not depend on the chain length.
+type UTXOSet struct {
+	outputs   map[OutPoint]*TxOutput
+	byAddress map[string]map[OutPoint]struct{}
+	balances  map[string]Amount
+	undo      map[[32]byte][]*UTXO
+
+	// The leaves of the state root as of the last Root, ordered by
+	// outpoint, that root, and the outpoints added or removed since.
+	leaves  []utxoLeaf
+	root    [32]byte
+	changed map[OutPoint]struct{}
+}
+
+type utxoLeaf struct {
+	op   OutPoint
+	hash [32]byte
+}
+
+func NewUTXOSet() *UTXOSet {
+	return &UTXOSet{
+		outputs:   make(map[OutPoint]*TxOutput),
+		byAddress: make(map[string]map[OutPoint]struct{}),
+		balances:  make(map[string]Amount),
+		undo:      make(map[[32]byte][]*UTXO),
+		changed:   make(map[OutPoint]struct{}),
+	}
+}
+
+// Get returns the unspent output at op, or nil if there is none.
+func (s *UTXOSet) Get(op OutPoint) *TxOutput {
+	return s.outputs[op]
+}
+
+func (s *UTXOSet) Balance(address string) Amount {
+	return s.balances[address]
+}
+
+// Unspent lists the outputs paying address, ordered by outpoint.
+func (s *UTXOSet) Unspent(address string) []*UTXO {
+	utxos := make([]*UTXO, 0, len(s.byAddress[address]))
+	for op := range s.byAddress[address] {
+		utxos = append(utxos, &UTXO{OutPoint: op, Output: s.outputs[op]})
+	}
+	sortUTXOs(utxos)
+	return utxos
+}
+
+func sortUTXOs(utxos []*UTXO) {
+	sort.Slice(utxos, func(i, j int) bool {
+		return outPointLess(utxos[i].OutPoint, utxos[j].OutPoint)
+	})
+}
+
+func outPointLess(a, b OutPoint) bool {
+	if c := bytes.Compare(a.TransactionHash[:], b.TransactionHash[:]); c != 0 {
+		return c < 0
+	}
+	return a.Index < b.Index
+}
+
+func (s *UTXOSet) add(op OutPoint, out *TxOutput) {
+	s.outputs[op] = out
+	s.changed[op] = struct{}{}
+	if s.byAddress[out.Address] == nil {
+		s.byAddress[out.Address] = make(map[OutPoint]struct{})
+	}
+	s.byAddress[out.Address][op] = struct{}{}
+	s.balances[out.Address] += out.Value
+}
+
+func (s *UTXOSet) remove(op OutPoint) *TxOutput {
+	out, ok := s.outputs[op]
+	if !ok {
+		return nil
+	}
+	delete(s.outputs, op)
+	s.changed[op] = struct{}{}
+	delete(s.byAddress[out.Address], op)
+	s.balances[out.Address] -= out.Value
+	if len(s.byAddress[out.Address]) == 0 {
+		delete(s.byAddress, out.Address)
+		delete(s.balances, out.Address)
+	}
+	return out
+}
+
+// Root commits to the whole set: the Merkle root of its outputs ordered by
+// outpoint. Nodes that agree on the root agree on every balance. The leaves
+// are kept between calls, so only the outputs added since are hashed and
+// merged in, and an unchanged set returns the last root.
+func (s *UTXOSet) Root() [32]byte {
+	if len(s.changed) == 0 {
+		return s.root
+	}
+
+	added := make([]utxoLeaf, 0, len(s.changed))
+	for op := range s.changed {
+		if out, ok := s.outputs[op]; ok {
+			added = append(added, utxoLeaf{op: op, hash: (&UTXO{OutPoint: op, Output: out}).Hash()})
+		}
+	}
+	sort.Slice(added, func(i, j int) bool {
+		return outPointLess(added[i].op, added[j].op)
+	})
+
+	// A new slice, so leaves saved by ConnectBlock or StateRoot stay valid.
+	leaves := make([]utxoLeaf, 0, len(s.outputs))
+	for _, l := range s.leaves {
+		if _, ok := s.changed[l.op]; ok {
+			continue
+		}
+		for len(added) > 0 && outPointLess(added[0].op, l.op) {
+			leaves = append(leaves, added[0])
+			added = added[1:]
+		}
+		leaves = append(leaves, l)
+	}
+	leaves = append(leaves, added...)
+
+	hashes := make([][32]byte, len(leaves))
+	for i, l := range leaves {
+		hashes[i] = l.hash
+	}
+	s.leaves, s.root = leaves, MerkleRoot(hashes)
+	clear(s.changed)
+	return s.root
+}
+
+// restoreRoot puts back the leaves and root Root returned for the set, once
+// the set is back in that state, sparing the next call a rebuild.
+func (s *UTXOSet) restoreRoot(leaves []utxoLeaf, root [32]byte) {
+	s.leaves, s.root = leaves, root
+	clear(s.changed)
+}
+
+// Hash commits to the outpoint, value and address of u.
+func (u *UTXO) Hash() [32]byte {
+	return sha256.Sum256(u.Bytes())
+}
+
+// ConnectBlock validates the transactions of b against the set and applies
+// them. The resulting set must match the state root in b's header. A block
+// that fails leaves the set unchanged.
+func (s *UTXOSet) ConnectBlock(b *Block) error {
+	root := s.Root()
+	leaves := s.leaves
+	spent, err := s.connectTransactions(b)
+	if err != nil {
+		s.restoreRoot(leaves, root)
+		return err
+	}
+
+	if s.Root() != b.StateRoot {
+		s.disconnectTransactions(b.Transactions, spent)
+		s.restoreRoot(leaves, root)
+		return fmt.Errorf("%w: block %d", ErrInvalidStateRoot, b.Height)
+	}
+
+	s.undo[b.Hash()] = spent
+	return nil
+}
+
+// StateRoot returns the root the set would have with b connected, for a
+// miner to commit to before searching for the proof-of-work.
+func (s *UTXOSet) StateRoot(b *Block) ([32]byte, error) {
+	root := s.Root()
+	leaves := s.leaves
+	spent, err := s.connectTransactions(b)
+	if err != nil {
+		s.restoreRoot(leaves, root)
+		return [32]byte{}, err
+	}
+
+	next := s.Root()
+	s.disconnectTransactions(b.Transactions, spent)
+	s.restoreRoot(leaves, root)
+	return next, nil
+}
+
+// DisconnectBlock reverts b, which must be the last block connected.
+func (s *UTXOSet) DisconnectBlock(b *Block) error {
+	spent, ok := s.undo[b.Hash()]
+	if !ok {
+		return fmt.Errorf("%w: block %d is not connected", ErrBlockNotFound, b.Height)
+	}
+	s.disconnectTransactions(b.Transactions, spent)
+	delete(s.undo, b.Hash())
+	return nil
+}
+
+// connectTransactions validates and applies the transactions of b in order,
+// returning the outputs they spent. On error nothing is applied.
+func (s *UTXOSet) connectTransactions(b *Block) ([]*UTXO, error) {
+	if size := BlockSize(b.Transactions); size > MAX_BLOCK_SIZE {
+		return nil, fmt.Errorf("%w: %d bytes in block %d", ErrBlockTooLarge, size, b.Height)
+	}
+	if err := validCoinbase(b); err != nil {
+		return nil, err
+	}
+
+	var spent []*UTXO
+	for i, t := range b.Transactions {
+		if i > 0 {
+			if err := VerifyTransaction(t, s.Get); err != nil {
+				s.disconnectTransactions(b.Transactions[:i], spent)
+				return nil, err
+			}
+		}
+		spent = append(spent, s.connectTransaction(t)...)
+	}
+	return spent, nil
+}
+
+func (s *UTXOSet) connectTransaction(t *Transaction) []*UTXO {
+	spent := make([]*UTXO, 0, len(t.Inputs))
+	for _, in := range t.Inputs {
+		spent = append(spent, &UTXO{OutPoint: in.PreviousOutput, Output: s.remove(in.PreviousOutput)})
+	}
+
+	hash := t.Hash()
+	for i, out := range t.Outputs {
+		s.add(OutPoint{TransactionHash: hash, Index: uint32(i)}, out)
+	}
+	return spent
+}
+
+// disconnectTransactions undoes transactions in reverse order, restoring the
+// outputs they spent from spent, which lists them in input order.
+func (s *UTXOSet) disconnectTransactions(transactions []*Transaction, spent []*UTXO) {
+	for i := len(transactions) - 1; i >= 0; i-- {
+		t := transactions[i]
+		hash := t.Hash()
+		for j := range t.Outputs {
+			s.remove(OutPoint{TransactionHash: hash, Index: uint32(j)})
+		}
+
+		restored := spent[len(spent)-len(t.Inputs):]
+		spent = spent[:len(spent)-len(t.Inputs)]
+		for _, u := range restored {
+			s.add(u.OutPoint, u.Output)
+		}
+	}
+}
+
+// VerifyTransaction checks a transaction that spends outputs found through
+// lookup: every input must name an unspent output, at most once, and carry a
+// signature of the transaction hash by the key owning that output. The outputs
+// must pay well-formed addresses and, with the fee, add up to exactly what the
+// inputs spend.
+func VerifyTransaction(t *Transaction, lookup func(OutPoint) *TxOutput) error {
+	if t.IsCoinbase() {
+		return rejectTransaction(t, ErrInvalidCoinbase, "rewards are only created by mining")
+	}
+
+	if len(t.Outputs) == 0 {
+		return rejectTransaction(t, ErrInvalidValue, "no outputs")
+	}
+	for _, out := range t.Outputs {
+		if !utils.ValidAddress(out.Address) {
+			return rejectTransaction(t, ErrInvalidAddress, "recipient %q", out.Address)
+		}
+	}
+	outputs, err := t.OutputValue()
+	if err != nil {
+		return err
+	}
+	if t.Fee < 0 || outputs+t.Fee < outputs {
+		return rejectTransaction(t, ErrInvalidValue, "fee of %s", t.Fee)
+	}
+	outputs += t.Fee
+
+	hash := t.Hash()
+	seen := make(map[OutPoint]bool, len(t.Inputs))
+	var inputs Amount
+	for _, in := range t.Inputs {
+		if seen[in.PreviousOutput] {
+			return rejectTransaction(t, ErrDoubleSpend, "%s spent twice", in.PreviousOutput)
+		}
+		seen[in.PreviousOutput] = true
+
+		previous := lookup(in.PreviousOutput)
+		if previous == nil {
+			return rejectTransaction(t, ErrMissingInput, "%s", in.PreviousOutput)
+		}
+
+		if !utils.ValidHexString(in.PublicKey, 128) || !utils.ValidHexString(in.Signature, 128) {
+			return rejectTransaction(t, ErrInvalidSignature, "malformed public key or signature")
+		}
+		publicKey := utils.StringToPublicKey(in.PublicKey)
+		if utils.PublicKeyToAddress(publicKey) != previous.Address {
+			return rejectTransaction(t, ErrSenderMismatch, "%s belongs to %s", in.PreviousOutput, previous.Address)
+		}
+		signature := utils.StringToSignature(in.Signature)
+		if !ecdsa.Verify(publicKey, hash[:], signature.R, signature.S) {
+			return rejectTransaction(t, ErrInvalidSignature, "input %s", in.PreviousOutput)
+		}
+
+		if inputs+previous.Value < inputs {
+			return rejectTransaction(t, ErrInvalidValue, "inputs overflow")
+		}
+		inputs += previous.Value
+	}
+
+	if inputs < outputs {
+		return rejectTransaction(t, ErrInsufficientBalance, "%s available, %s spent", inputs, outputs)
+	}
+	if inputs > outputs {
+		return rejectTransaction(t, ErrInvalidValue, "inputs of %s do not match outputs and fee of %s", inputs, outputs)
+	}
+	return nil
+}
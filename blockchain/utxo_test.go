@@ -0,0 +1,90 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"math/rand"
+	"testing"
+)
+
+// rebuiltRoot hashes and orders every output of s from scratch.
+func rebuiltRoot(s *UTXOSet) [32]byte {
+	utxos := make([]*UTXO, 0, len(s.outputs))
+	for op, out := range s.outputs {
+		utxos = append(utxos, &UTXO{OutPoint: op, Output: out})
+	}
+	sortUTXOs(utxos)
+
+	leaves := make([][32]byte, len(utxos))
+	for i, u := range utxos {
+		leaves[i] = u.Hash()
+	}
+	return MerkleRoot(leaves)
+}
+
+func TestUTXORootFollowsChanges(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	s := NewUTXOSet()
+	var ops []OutPoint
+
+	for round := 0; round < 200; round++ {
+		for k := r.Intn(5); k > 0; k-- {
+			op := OutPoint{TransactionHash: sha256.Sum256(binary.BigEndian.AppendUint64(nil, r.Uint64())), Index: uint32(r.Intn(3))}
+			s.add(op, &TxOutput{Address: "addr", Value: Amount(r.Intn(1000))})
+			ops = append(ops, op)
+		}
+		for k := r.Intn(4); k > 0 && len(ops) > 0; k-- {
+			i := r.Intn(len(ops))
+			s.remove(ops[i])
+			ops = append(ops[:i], ops[i+1:]...)
+		}
+		// Spending an output and paying the same back leaves the set as it was.
+		if len(ops) > 0 && r.Intn(4) == 0 {
+			op := ops[r.Intn(len(ops))]
+			s.add(op, s.remove(op))
+		}
+
+		if got, want := s.Root(), rebuiltRoot(s); got != want {
+			t.Fatalf("round %d: root %x, rebuilt %x", round, got, want)
+		}
+	}
+}
+
+func TestStateRootLeavesSetUnchanged(t *testing.T) {
+	bc := newTestChain(t, t.TempDir())
+	miner := newTestKey(t)
+	mineBlock(t, bc, miner.address)
+	before := bc.utxos.Root()
+
+	height := uint64(len(bc.Chain))
+	reward := NewCoinbaseTransaction(miner.address, MINING_REWARD, height)
+	b := NewBlock(height, NextBits(bc.Chain), bc.LastBlock().Hash(), []*Transaction{reward})
+	stateRoot, err := bc.utxos.StateRoot(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.StateRoot = stateRoot
+	if b.StateRoot == before {
+		t.Fatal("template commits to the state before its block")
+	}
+	if got := bc.utxos.Root(); got != before || got != rebuiltRoot(bc.utxos) {
+		t.Fatalf("root %x after StateRoot, want %x", got, before)
+	}
+
+	// A block committing to the wrong state is refused and changes nothing.
+	bad := *b
+	bad.StateRoot = before
+	if err := bc.utxos.ConnectBlock(&bad); err == nil {
+		t.Fatal("block with a wrong state root connected")
+	}
+	if got := bc.utxos.Root(); got != before || got != rebuiltRoot(bc.utxos) {
+		t.Fatalf("root %x after a refused block, want %x", got, before)
+	}
+
+	if err := bc.utxos.ConnectBlock(b); err != nil {
+		t.Fatal(err)
+	}
+	if got := bc.utxos.Root(); got != b.StateRoot || got != rebuiltRoot(bc.utxos) {
+		t.Fatalf("root %x after connecting, want %x", got, b.StateRoot)
+	}
+}
@@ -0,0 +1,186 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Every message on a peer connection is framed as
+//
+//	magic    4 bytes  the first bytes of sha256(network id)
+//	command  1 byte
+//	length   4 bytes  of the payload, big endian
+//	checksum 4 bytes  the first bytes of sha256(payload)
+//	payload
+//
+// The magic keeps nodes of different networks from reading each other's
+// messages, and lets a reader notice it lost track of the framing.
+const (
+	MSG_VERSION byte = iota + 1
+	MSG_VERACK
+	MSG_INV
+	MSG_GETDATA
+	MSG_BLOCK
+	MSG_TX
+	MSG_PING
+	MSG_PONG
+	MSG_GETHEADERS
+	MSG_HEADERS
+	MSG_NOTFOUND
+)
+
+const (
+	INV_TX    byte = 1
+	INV_BLOCK byte = 2
+
+	MESSAGE_HEADER_SIZE = 4 + 1 + 4 + 4
+	// A block message or a full page of headers is the largest; the header
+	// and encoding overhead fit well within the slack.
+	MAX_MESSAGE_SIZE   = 2 * max(MAX_BLOCK_SIZE, MAX_HEADERS_PER_REQUEST*BLOCK_HEADER_SIZE)
+	MAX_INV_ITEMS      = 1000
+	MAX_LOCATOR_HASHES = 100
+)
+
+var ErrMalformedMessage = errors.New("malformed peer message")
+
+func networkMagic(network string) [4]byte {
+	h := sha256.Sum256([]byte(network))
+	return [4]byte(h[:4])
+}
+
+func messageChecksum(payload []byte) [4]byte {
+	h := sha256.Sum256(payload)
+	return [4]byte(h[:4])
+}
+
+// frameMessage wraps payload in the message framing.
+func frameMessage(magic [4]byte, command byte, payload []byte) []byte {
+	checksum := messageChecksum(payload)
+	buf := make([]byte, 0, MESSAGE_HEADER_SIZE+len(payload))
+	buf = append(buf, magic[:]...)
+	buf = append(buf, command)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(payload)))
+	buf = append(buf, checksum[:]...)
+	return append(buf, payload...)
+}
+
+// readMessage reads the next message from r and checks its framing.
+func readMessage(r io.Reader, magic [4]byte) (byte, []byte, error) {
+	header := make([]byte, MESSAGE_HEADER_SIZE)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	if !bytes.Equal(header[:4], magic[:]) {
+		return 0, nil, fmt.Errorf("%w: wrong network magic %x", ErrMalformedMessage, header[:4])
+	}
+
+	command := header[4]
+	length := binary.BigEndian.Uint32(header[5:9])
+	if length > MAX_MESSAGE_SIZE {
+		return 0, nil, fmt.Errorf("%w: %d byte payload", ErrMalformedMessage, length)
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	if checksum := messageChecksum(payload); !bytes.Equal(header[9:], checksum[:]) {
+		return 0, nil, fmt.Errorf("%w: checksum mismatch", ErrMalformedMessage)
+	}
+	return command, payload, nil
+}
+
+// InvItem announces, or asks for, a transaction or block by hash.
+type InvItem struct {
+	Type byte
+	Hash [32]byte
+}
+
+func encodeInv(items []InvItem) []byte {
+	buf := binary.AppendUvarint(nil, uint64(len(items)))
+	for _, item := range items {
+		buf = append(buf, item.Type)
+		buf = append(buf, item.Hash[:]...)
+	}
+	return buf
+}
+
+func decodeInv(data []byte) ([]InvItem, error) {
+	d := &decoder{buf: data}
+	items := make([]InvItem, d.count(1+32))
+	if len(items) > MAX_INV_ITEMS {
+		return nil, fmt.Errorf("%w: %d inventory items", ErrMalformedMessage, len(items))
+	}
+	for i := range items {
+		items[i].Type = d.uint8()
+		items[i].Hash = d.hash()
+	}
+	return items, d.end()
+}
+
+func encodeVersion(v *VersionMessage) []byte {
+	buf := binary.BigEndian.AppendUint32(nil, v.Version)
+	buf = appendString(buf, v.Network)
+	buf = appendString(buf, v.From)
+	buf = appendString(buf, v.Listen)
+	buf = binary.BigEndian.AppendUint64(buf, v.Height)
+	buf = append(buf, v.TipHash[:]...)
+	return binary.BigEndian.AppendUint64(buf, v.Services)
+}
+
+func decodeVersion(data []byte) (*VersionMessage, error) {
+	d := &decoder{buf: data}
+	v := new(VersionMessage)
+	v.Version = d.uint32()
+	v.Network = d.string()
+	v.From = d.string()
+	v.Listen = d.string()
+	v.Height = d.uint64()
+	v.TipHash = d.hash()
+	v.Services = d.uint64()
+	return v, d.end()
+}
+
+func encodeLocator(locator [][32]byte) []byte {
+	buf := binary.AppendUvarint(nil, uint64(len(locator)))
+	for _, hash := range locator {
+		buf = append(buf, hash[:]...)
+	}
+	return buf
+}
+
+func decodeLocator(data []byte) ([][32]byte, error) {
+	d := &decoder{buf: data}
+	locator := make([][32]byte, d.count(32))
+	if len(locator) > MAX_LOCATOR_HASHES {
+		return nil, fmt.Errorf("%w: %d locator hashes", ErrMalformedMessage, len(locator))
+	}
+	for i := range locator {
+		locator[i] = d.hash()
+	}
+	return locator, d.end()
+}
+
+func encodeHeaders(headers []*BlockHeader) []byte {
+	buf := binary.AppendUvarint(nil, uint64(len(headers)))
+	for _, h := range headers {
+		buf = append(buf, h.Bytes()...)
+	}
+	return buf
+}
+
+func decodeHeaders(data []byte) ([]*BlockHeader, error) {
+	d := &decoder{buf: data}
+	headers := make([]*BlockHeader, d.count(BLOCK_HEADER_SIZE))
+	if len(headers) > MAX_HEADERS_PER_REQUEST {
+		return nil, fmt.Errorf("%w: %d headers", ErrMalformedMessage, len(headers))
+	}
+	for i := range headers {
+		headers[i] = d.header()
+	}
+	return headers, d.end()
+}
@@ -1,26 +1,49 @@
 package main
 
 import (
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/jvsena42/go_blockchain/blockchain"
 	"github.com/jvsena42/go_blockchain/utils"
-	"github.com/jvsena42/go_blockchain/wallet"
 )
 
 var cache map[string]*blockchain.Blockchain = make(map[string]*blockchain.Blockchain)
 
 type BlockchainNode struct {
-	port uint16
+	port      uint16
+	dataDir   string
+	advertise string
+	p2pPort   uint16
+	network   string
+	seeds     []string
+	threads   int
+	mine      bool
+	payout    string
 }
 
-func NewBlockchainNode(port uint16) *BlockchainNode {
+// NewBlockchainNode creates a node on network that peers reach at advertise,
+// and over peer connections on p2pPort, and that starts discovering the
+// network from seeds. It mines with threads goroutines, one per CPU if threads
+// is 0, paying rewards to payout, and starts mining right away if mine is set.
+func NewBlockchainNode(port uint16, dataDir string, advertise string, p2pPort uint16, network string, seeds []string, threads int, mine bool, payout string) *BlockchainNode {
 	return &BlockchainNode{
-		port: port,
+		port:      port,
+		dataDir:   dataDir,
+		advertise: advertise,
+		p2pPort:   p2pPort,
+		network:   network,
+		seeds:     seeds,
+		threads:   threads,
+		mine:      mine,
+		payout:    payout,
 	}
 }
 
@@ -32,8 +55,20 @@ func (bcn *BlockchainNode) GetBlockchain() *blockchain.Blockchain {
 	bc, ok := cache["blockchain"]
 
 	if !ok {
-		minerWallet := wallet.NewWallet()
-		bc = blockchain.NewBlockchain(minerWallet.BlockchainAddress(), bcn.Port())
+		var err error
+		bc, err = blockchain.NewBlockchain(bcn.Port(), bcn.dataDir)
+		if err != nil {
+			log.Fatalf("ERROR: could not open blockchain in %s: %v", bcn.dataDir, err)
+		}
+		bc.SetNetwork(bcn.network)
+		bc.SetMinerWorkers(bcn.threads)
+		bc.UsePeers(bcn.advertise, bcn.seeds)
+
+		host, _, _ := net.SplitHostPort(bcn.advertise)
+		p2pPort := strconv.Itoa(int(bcn.p2pPort))
+		if err := bc.ListenPeers(net.JoinHostPort("", p2pPort), net.JoinHostPort(host, p2pPort)); err != nil {
+			log.Fatalf("ERROR: could not listen for peers on port %s: %v", p2pPort, err)
+		}
 		cache["blockchain"] = bc
 	}
 
@@ -94,60 +129,231 @@ func (bcn *BlockchainNode) Transactions(w http.ResponseWriter, r *http.Request)
 			return
 		}
 
-		publicKey := utils.StringToPublicKey(*t.SenderPublicKey)
-		signature := utils.StringToSignature(*t.Signature)
 		bc := bcn.GetBlockchain()
-
-		isCreated := bc.CreateTransaction(*t.SenderBlockchainAddress, *t.RecipientBlockchainAddress, *t.Value, publicKey, signature)
+		err = bc.CreateTransaction(t.Transaction())
 
 		w.Header().Add("Content-Type", "application/json")
 		var responseByte []byte
-		if !isCreated {
+		if err != nil {
+			log.Printf("ERROR: transaction rejected: %v", err)
 			w.WriteHeader(http.StatusBadRequest)
-			responseByte = utils.JsonStatus("Fail creating transaction")
+			responseByte = utils.JsonStatus("Fail creating transaction: " + err.Error())
 		} else {
 			w.WriteHeader(http.StatusCreated)
 			responseByte = utils.JsonStatus("Success!")
 		}
 		io.WriteString(w, string(responseByte))
 
-	case http.MethodPut:
-		decoder := json.NewDecoder((r.Body))
-		var t blockchain.TransactionRequest
-		err := decoder.Decode(&t)
+	default:
+		log.Println("ERROR: Invalid http method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+type MineRequest struct {
+	Address *string `json:"address"`
+}
+
+// StartMine starts the background miner, paying the address in the request
+// or, without one, the node's payout address. Only the node operator may.
+func (bcn *BlockchainNode) StartMine(w http.ResponseWriter, r *http.Request) {
+	if !fromLoopback(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		w.Header().Add("Content-Type", "application/json")
+
+		var req MineRequest
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				io.WriteString(w, string(utils.JsonStatus("ERROR: invalid request")))
+				return
+			}
+		}
+		address := bcn.payout
+		if req.Address != nil {
+			address = *req.Address
+		}
+
+		bc := bcn.GetBlockchain()
+		if err := bc.StartMiner(address); err != nil {
+			if errors.Is(err, blockchain.ErrMinerRunning) {
+				w.WriteHeader(http.StatusConflict)
+			} else {
+				w.WriteHeader(http.StatusBadRequest)
+			}
+			io.WriteString(w, string(utils.JsonStatus("ERROR: "+err.Error())))
+			return
+		}
+		m, _ := json.Marshal(bc.MinerStatus())
+		io.WriteString(w, string(m[:]))
+
+	default:
+		log.Println("ERROR: Invalid http method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// StopMine stops the background miner. Stopping an idle miner is not an
+// error; the status tells either way.
+func (bcn *BlockchainNode) StopMine(w http.ResponseWriter, r *http.Request) {
+	if !fromLoopback(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		bc := bcn.GetBlockchain()
+		bc.StopMiner()
+
+		w.Header().Add("Content-Type", "application/json")
+		m, _ := json.Marshal(bc.MinerStatus())
+		io.WriteString(w, string(m[:]))
+
+	default:
+		log.Println("ERROR: Invalid http method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func (bcn *BlockchainNode) MineStatus(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Add("Content-Type", "application/json")
+		m, _ := json.Marshal(bcn.GetBlockchain().MinerStatus())
+		io.WriteString(w, string(m[:]))
+
+	default:
+		log.Println("ERROR: Invalid http method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// MineTemplate serves the block an external miner should work on, paying the
+// reward to the address given in the query.
+func (bcn *BlockchainNode) MineTemplate(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Add("Content-Type", "application/json")
 
+		tmpl, err := bcn.GetBlockchain().BlockTemplate(r.URL.Query().Get("address"))
 		if err != nil {
 			log.Printf("ERROR: %v", err)
-			io.WriteString(w, string(utils.JsonStatus("Error decode")))
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatus("ERROR: "+err.Error())))
 			return
 		}
+		m, _ := json.Marshal(tmpl)
+		io.WriteString(w, string(m[:]))
 
-		if !t.Valid() {
-			log.Println("ERROR: Missing fields!")
-			io.WriteString(w, string(utils.JsonStatus("ERROR: Missing fields!")))
+	default:
+		log.Println("ERROR: Invalid http method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+type SubmitBlockRequest struct {
+	Block *string `json:"block"`
+}
+
+// MineSubmit takes a block solved by an external miner, hex encoded, and
+// connects and relays it.
+func (bcn *BlockchainNode) MineSubmit(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		w.Header().Add("Content-Type", "application/json")
+
+		var req SubmitBlockRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Block == nil {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatus("ERROR: block is required")))
+			return
+		}
+		data, err := hex.DecodeString(*req.Block)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatus("ERROR: block is not hex encoded")))
+			return
+		}
+		b, err := blockchain.DecodeBlock(data)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatus("ERROR: "+err.Error())))
 			return
 		}
 
-		publicKey := utils.StringToPublicKey(*t.SenderPublicKey)
-		signature := utils.StringToSignature(*t.Signature)
-		bc := bcn.GetBlockchain()
+		if err := bcn.GetBlockchain().SubmitBlock(b); err != nil {
+			log.Printf("ERROR: submitted block rejected: %v", err)
+			if errors.Is(err, blockchain.ErrStaleBlock) {
+				w.WriteHeader(http.StatusConflict)
+			} else {
+				w.WriteHeader(http.StatusBadRequest)
+			}
+			io.WriteString(w, string(utils.JsonStatus("ERROR: "+err.Error())))
+			return
+		}
+		io.WriteString(w, string(utils.JsonStatus("success")))
 
-		isUpdated := bc.AddTransaction(*t.SenderBlockchainAddress, *t.RecipientBlockchainAddress, *t.Value, publicKey, signature)
+	default:
+		log.Println("ERROR: Invalid http method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func (bcn *BlockchainNode) Amount(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		blockchainAddress := r.URL.Query().Get("blockchain_address")
+		amountResponse := bcn.GetBlockchain().Amount(blockchainAddress)
+		m, _ := json.Marshal(amountResponse)
 
 		w.Header().Add("Content-Type", "application/json")
-		var responseByte []byte
-		if !isUpdated {
+		io.WriteString(w, string(m[:]))
+
+	default:
+		log.Println("ERROR: Invalid http method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// UTXOs lists the outputs a wallet can spend, including change from its
+// pending transactions.
+func (bcn *BlockchainNode) UTXOs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		blockchainAddress := r.URL.Query().Get("blockchain_address")
+		utxos := bcn.GetBlockchain().SpendableOutputs(blockchainAddress)
+		m, _ := json.Marshal(&blockchain.UTXOResponse{UTXOs: utxos})
+
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(m[:]))
+
+	default:
+		log.Println("ERROR: Invalid http method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func (bcn *BlockchainNode) Headers(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Add("Content-Type", "application/json")
+
+		from, err := strconv.Atoi(r.URL.Query().Get("from"))
+		if err != nil || from < 0 {
 			w.WriteHeader(http.StatusBadRequest)
-			responseByte = utils.JsonStatus("Fail adding transaction")
-		} else {
-			responseByte = utils.JsonStatus("Success!")
+			io.WriteString(w, string(utils.JsonStatus("ERROR: invalid from height")))
+			return
 		}
-		io.WriteString(w, string(responseByte))
 
-	case http.MethodDelete:
-		bc := bcn.GetBlockchain()
-		bc.ClearTransactionPool()
-		io.WriteString(w, string(utils.JsonStatus("success")))
+		headers := bcn.GetBlockchain().Headers(from)
+		m, _ := json.Marshal(&blockchain.HeadersResponse{Headers: headers})
+		io.WriteString(w, string(m[:]))
 
 	default:
 		log.Println("ERROR: Invalid http method")
@@ -155,21 +361,65 @@ func (bcn *BlockchainNode) Transactions(w http.ResponseWriter, r *http.Request)
 	}
 }
 
-func (bcn *BlockchainNode) Mine(w http.ResponseWriter, r *http.Request) {
+func (bcn *BlockchainNode) TransactionProof(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
-		bc := bcn.GetBlockchain()
-		isMined := bc.Mining()
+		w.Header().Add("Content-Type", "application/json")
 
-		var messageByte []byte
-		if !isMined {
+		var hash [32]byte
+		id, err := hex.DecodeString(r.PathValue("id"))
+		if err != nil || len(id) != len(hash) {
 			w.WriteHeader(http.StatusBadRequest)
-			messageByte = utils.JsonStatus("Fail mining transacion pool")
-		} else {
-			messageByte = utils.JsonStatus("Mining success!")
+			io.WriteString(w, string(utils.JsonStatus("ERROR: invalid transaction id")))
+			return
 		}
+		copy(hash[:], id)
+
+		proof, err := bcn.GetBlockchain().TransactionProof(hash)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, string(utils.JsonStatus(err.Error())))
+			return
+		}
+
+		m, _ := json.Marshal(proof)
+		io.WriteString(w, string(m[:]))
+
+	default:
+		log.Println("ERROR: Invalid http method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// Blocks receives a block submitted over HTTP and announces it to our peers
+// if it extended our chain. Peers themselves send blocks over their
+// connections.
+func (bcn *BlockchainNode) Blocks(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
 		w.Header().Add("Content-Type", "application/json")
-		io.WriteString(w, string(messageByte))
+
+		var b blockchain.Block
+		if err := json.NewDecoder(r.Body).Decode(&b); err != nil || !b.WellFormed() {
+			log.Printf("ERROR: malformed block: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatus("Error decode")))
+			return
+		}
+
+		bc := bcn.GetBlockchain()
+		connected, err := bc.ReceiveBlock(&b)
+		if err != nil {
+			log.Printf("ERROR: block rejected: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatus("Fail adding block: "+err.Error())))
+			return
+		}
+
+		if connected {
+			bc.RelayBlock(&b)
+		}
+		io.WriteString(w, string(utils.JsonStatus("success")))
 
 	default:
 		log.Println("ERROR: Invalid http method")
@@ -177,16 +427,79 @@ func (bcn *BlockchainNode) Mine(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (bcn *BlockchainNode) StartMine(w http.ResponseWriter, r *http.Request) {
+// Peers exchanges peer addresses: a POST carries the sender's address and the
+// peers it knows and is answered with ours, a GET lists the peer table.
+func (bcn *BlockchainNode) Peers(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
+		w.Header().Add("Content-Type", "application/json")
+		m, _ := json.Marshal(struct {
+			Neighbors   []string                              `json:"neighbors"`
+			Connections []string                              `json:"connections"`
+			Versions    map[string]*blockchain.VersionMessage `json:"versions"`
+			Peers       []*blockchain.PeerInfo                `json:"peers"`
+		}{
+			Neighbors:   bcn.GetBlockchain().Neighbors(),
+			Connections: bcn.GetBlockchain().Connections(),
+			Versions:    bcn.GetBlockchain().PeerVersions(),
+			Peers:       bcn.GetBlockchain().Peers(),
+		})
+		io.WriteString(w, string(m[:]))
+
+	case http.MethodPost:
+		w.Header().Add("Content-Type", "application/json")
+
+		var msg blockchain.PeersMessage
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatus("Error decode")))
+			return
+		}
 		bc := bcn.GetBlockchain()
-		bc.StartMining()
+		if refuseBanned(w, bc, r, bc.InboundPeer(msg.From, r.RemoteAddr)) {
+			return
+		}
+
+		m, _ := json.Marshal(bc.ReceivePeers(&msg, r.RemoteAddr))
+		io.WriteString(w, string(m[:]))
 
-		messageByte := utils.JsonStatus("Mining success!")
+	default:
+		log.Println("ERROR: Invalid http method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
 
+// Version answers the handshake another node opens a connection with, or
+// refuses it if the node is on another network or protocol version.
+func (bcn *BlockchainNode) Version(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
 		w.Header().Add("Content-Type", "application/json")
-		io.WriteString(w, string(messageByte))
+
+		var v blockchain.VersionMessage
+		if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatus("Error decode")))
+			return
+		}
+
+		ours, err := bcn.GetBlockchain().ReceiveVersion(&v, r.RemoteAddr)
+		if errors.Is(err, blockchain.ErrBannedPeer) {
+			w.WriteHeader(http.StatusForbidden)
+			io.WriteString(w, string(utils.JsonStatus(err.Error())))
+			return
+		}
+		if err != nil {
+			log.Printf("ERROR: refused handshake from %s: %v", v.From, err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatus(err.Error())))
+			return
+		}
+
+		m, _ := json.Marshal(ours)
+		io.WriteString(w, string(m[:]))
 
 	default:
 		log.Println("ERROR: Invalid http method")
@@ -194,23 +507,101 @@ func (bcn *BlockchainNode) StartMine(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (bcn *BlockchainNode) Amount(w http.ResponseWriter, r *http.Request) {
+// refuseBanned answers a request from the host of a banned peer, or from
+// the banned peer at address from, with 403 Forbidden, reporting whether it
+// did.
+func refuseBanned(w http.ResponseWriter, bc *blockchain.Blockchain, r *http.Request, from string) bool {
+	if !bc.BannedRemote(r.RemoteAddr) && !bc.Banned(from) {
+		return false
+	}
+	w.WriteHeader(http.StatusForbidden)
+	io.WriteString(w, string(utils.JsonStatus("ERROR: peer is banned")))
+	return true
+}
+
+type BanRequest struct {
+	Address     *string `json:"address"`
+	DurationSec *int64  `json:"duration_sec"`
+}
+
+// Bans lets the node operator list banned peers and ban one by hand, for
+// BAN_DURATION unless duration_sec says otherwise. It only answers requests
+// from this machine.
+func (bcn *BlockchainNode) Bans(w http.ResponseWriter, r *http.Request) {
+	if !fromLoopback(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
 	switch r.Method {
 	case http.MethodGet:
-		blockchainAddress := r.URL.Query().Get("blockchain_address")
-		amount := bcn.GetBlockchain().CalculateTotalAmount(blockchainAddress)
-		amountResponse := &blockchain.AmountResponse{Amount: amount}
-		m, _ := json.Marshal(amountResponse)
-
 		w.Header().Add("Content-Type", "application/json")
+		m, _ := json.Marshal(struct {
+			Bans []*blockchain.PeerInfo `json:"bans"`
+		}{
+			Bans: bcn.GetBlockchain().Bans(),
+		})
 		io.WriteString(w, string(m[:]))
 
+	case http.MethodPost:
+		w.Header().Add("Content-Type", "application/json")
+
+		var req BanRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Address == nil {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatus("ERROR: address is required")))
+			return
+		}
+
+		duration := blockchain.BAN_DURATION
+		if req.DurationSec != nil {
+			duration = time.Duration(*req.DurationSec) * time.Second
+		}
+		if duration <= 0 || !bcn.GetBlockchain().Ban(*req.Address, duration) {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatus("ERROR: invalid address or duration")))
+			return
+		}
+		io.WriteString(w, string(utils.JsonStatus("success")))
+
+	default:
+		log.Println("ERROR: Invalid http method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// Unban lifts the ban on the peer at the given address.
+func (bcn *BlockchainNode) Unban(w http.ResponseWriter, r *http.Request) {
+	if !fromLoopback(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodDelete:
+		w.Header().Add("Content-Type", "application/json")
+		if !bcn.GetBlockchain().Unban(r.PathValue("address")) {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, string(utils.JsonStatus("ERROR: peer is not banned")))
+			return
+		}
+		io.WriteString(w, string(utils.JsonStatus("success")))
+
 	default:
 		log.Println("ERROR: Invalid http method")
 		w.WriteHeader(http.StatusBadRequest)
 	}
 }
 
+func fromLoopback(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
 func (bcn *BlockchainNode) Consensus(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
@@ -232,13 +623,30 @@ func (bcn *BlockchainNode) Consensus(w http.ResponseWriter, r *http.Request) {
 }
 
 func (bcn *BlockchainNode) Run() {
-	bcn.GetBlockchain().Run()
+	bc := bcn.GetBlockchain()
+	bc.Run()
+	if bcn.mine {
+		if err := bc.StartMiner(bcn.payout); err != nil {
+			log.Fatalf("ERROR: could not start mining: %v", err)
+		}
+	}
 
 	http.HandleFunc("/", bcn.GetChain)
 	http.HandleFunc("/transactions", bcn.Transactions)
-	http.HandleFunc("/mine", bcn.Mine)
 	http.HandleFunc("/mine/start", bcn.StartMine)
+	http.HandleFunc("/mine/stop", bcn.StopMine)
+	http.HandleFunc("/mine/status", bcn.MineStatus)
+	http.HandleFunc("/mine/template", bcn.MineTemplate)
+	http.HandleFunc("/mine/submit", bcn.MineSubmit)
 	http.HandleFunc("/amount", bcn.Amount)
+	http.HandleFunc("/utxos", bcn.UTXOs)
+	http.HandleFunc("/headers", bcn.Headers)
+	http.HandleFunc("/tx/{id}/proof", bcn.TransactionProof)
+	http.HandleFunc("/blocks", bcn.Blocks)
+	http.HandleFunc("/peers", bcn.Peers)
+	http.HandleFunc("/version", bcn.Version)
+	http.HandleFunc("/peers/bans", bcn.Bans)
+	http.HandleFunc("/peers/bans/{address}", bcn.Unban)
 	http.HandleFunc("/consensus", bcn.Consensus)
 
 	log.Fatal(http.ListenAndServe("0.0.0.0:"+strconv.Itoa(int(bcn.port)), nil))
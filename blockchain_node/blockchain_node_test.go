@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jvsena42/go_blockchain/blockchain"
+	"github.com/jvsena42/go_blockchain/wallet"
+)
+
+// newTestNode serves a fresh chain in a temporary directory.
+func newTestNode(t *testing.T) (*BlockchainNode, *blockchain.Blockchain) {
+	t.Helper()
+	dir := t.TempDir()
+	bc, err := blockchain.NewBlockchain(0, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache["blockchain"] = bc
+	t.Cleanup(func() { delete(cache, "blockchain") })
+	return NewBlockchainNode(0, dir, "", 0, blockchain.DEFAULT_NETWORK, nil, 1, false, ""), bc
+}
+
+func TestPostBlockRejectsNulls(t *testing.T) {
+	bcn, bc := newTestNode(t)
+	tip := bc.LastBlock().Hash()
+
+	for _, body := range []string{
+		`{"transactions":[null]}`,
+		`{"transactions":[{"inputs":[null],"outputs":[]}]}`,
+		`{"transactions":[{"inputs":[],"outputs":[null]}]}`,
+	} {
+		w := httptest.NewRecorder()
+		bcn.Blocks(w, httptest.NewRequest(http.MethodPost, "/blocks", strings.NewReader(body)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want %d", body, w.Code, http.StatusBadRequest)
+		}
+	}
+	if bc.LastBlock().Hash() != tip {
+		t.Error("tip moved")
+	}
+}
+
+// mineRequest calls handler as the node operator would, from loopback.
+func mineRequest(t *testing.T, handler http.HandlerFunc, body string) (int, *blockchain.MinerStatus) {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "/mine", strings.NewReader(body))
+	r.RemoteAddr = "127.0.0.1:40000"
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	var status blockchain.MinerStatus
+	json.NewDecoder(w.Body).Decode(&status)
+	return w.Code, &status
+}
+
+func TestMinerLifecycle(t *testing.T) {
+	bcn, bc := newTestNode(t)
+	address := wallet.NewWallet().BlockchainAddress()
+	t.Cleanup(func() { bc.StopMiner() })
+
+	if code, _ := mineRequest(t, bcn.StartMine, `{"address":"nobody"}`); code != http.StatusBadRequest {
+		t.Errorf("start with an invalid address: status %d, want %d", code, http.StatusBadRequest)
+	}
+
+	code, status := mineRequest(t, bcn.StartMine, `{"address":"`+address+`"}`)
+	if code != http.StatusOK || !status.Mining || status.Address != address {
+		t.Fatalf("start: status %d %+v, want mining to %s", code, status, address)
+	}
+	if code, _ := mineRequest(t, bcn.StartMine, `{"address":"`+address+`"}`); code != http.StatusConflict {
+		t.Errorf("second start: status %d, want %d", code, http.StatusConflict)
+	}
+
+	w := httptest.NewRecorder()
+	bcn.MineStatus(w, httptest.NewRequest(http.MethodGet, "/mine/status", nil))
+	if !strings.Contains(w.Body.String(), `"mining":true`) {
+		t.Errorf("status while mining: %s", w.Body)
+	}
+
+	// Only the node operator may stop the miner.
+	w = httptest.NewRecorder()
+	bcn.StopMine(w, httptest.NewRequest(http.MethodPost, "/mine/stop", nil))
+	if w.Code != http.StatusForbidden || !bc.MinerStatus().Mining {
+		t.Errorf("remote stop: status %d, want %d with the miner running", w.Code, http.StatusForbidden)
+	}
+
+	if code, status := mineRequest(t, bcn.StopMine, ""); code != http.StatusOK || status.Mining {
+		t.Errorf("stop: status %d %+v, want the miner idle", code, status)
+	}
+	if code, status := mineRequest(t, bcn.StopMine, ""); code != http.StatusOK || status.Mining {
+		t.Errorf("second stop: status %d %+v, want the miner idle", code, status)
+	}
+}
+
+// solveTemplate asks the node for a template paying address and solves it
+// the way an external miner would, from the hex alone.
+func solveTemplate(t *testing.T, bcn *BlockchainNode, bc *blockchain.Blockchain, address string) *blockchain.Block {
+	t.Helper()
+	w := httptest.NewRecorder()
+	bcn.MineTemplate(w, httptest.NewRequest(http.MethodGet, "/mine/template?address="+address, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("template: status %d: %s", w.Code, w.Body)
+	}
+	var tmpl blockchain.BlockTemplate
+	if err := json.NewDecoder(w.Body).Decode(&tmpl); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := hex.DecodeString(tmpl.Header)
+	data = binary.AppendUvarint(data, uint64(len(tmpl.Transactions)))
+	for _, tx := range tmpl.Transactions {
+		raw, _ := hex.DecodeString(tx.Data)
+		data = append(data, raw...)
+	}
+	b, err := blockchain.DecodeBlock(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for !bc.ValidProof(&b.BlockHeader) {
+		b.Nonce++
+	}
+	return b
+}
+
+func submitBlock(bcn *BlockchainNode, b *blockchain.Block) *httptest.ResponseRecorder {
+	body := `{"block":"` + hex.EncodeToString(b.Bytes()) + `"}`
+	w := httptest.NewRecorder()
+	bcn.MineSubmit(w, httptest.NewRequest(http.MethodPost, "/mine/submit", strings.NewReader(body)))
+	return w
+}
+
+func TestSubmitSolvedTemplate(t *testing.T) {
+	bcn, bc := newTestNode(t)
+	address := wallet.NewWallet().BlockchainAddress()
+
+	b := solveTemplate(t, bcn, bc, address)
+	if w := submitBlock(bcn, b); w.Code != http.StatusOK {
+		t.Fatalf("submit: status %d: %s", w.Code, w.Body)
+	}
+	if bc.LastBlock().Hash() != b.Hash() {
+		t.Fatal("submitted block is not the tip")
+	}
+	if balance := bc.CalculateTotalAmount(address); balance != blockchain.MINING_REWARD {
+		t.Errorf("reward paid %s, want %s", balance, blockchain.MINING_REWARD)
+	}
+}
+
+func TestSubmitStaleTemplate(t *testing.T) {
+	bcn, bc := newTestNode(t)
+	address := wallet.NewWallet().BlockchainAddress()
+
+	stale := solveTemplate(t, bcn, bc, address)
+	if blockchain.NewMiner(bc, 1).MineBlock(address, nil) == nil {
+		t.Fatal("block not mined")
+	}
+	tip := bc.LastBlock().Hash()
+
+	if w := submitBlock(bcn, stale); w.Code != http.StatusConflict {
+		t.Errorf("stale submit: status %d, want %d: %s", w.Code, http.StatusConflict, w.Body)
+	}
+	if bc.LastBlock().Hash() != tip {
+		t.Error("tip moved")
+	}
+}
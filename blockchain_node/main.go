@@ -2,7 +2,14 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/jvsena42/go_blockchain/blockchain"
+	"github.com/jvsena42/go_blockchain/utils"
 )
 
 func init() {
@@ -11,9 +18,41 @@ func init() {
 
 func main() {
 	port := flag.Uint("port", 3333, "TCP Port Number for Blockchain Node")
+	dataDir := flag.String("datadir", "", "Directory for the chain and transaction pool (default data/<port>)")
+	advertise := flag.String("advertise", "", "Address other nodes reach this node at (default 127.0.0.1:<port>)")
+	p2pPort := flag.Uint("p2p-port", 0, "TCP Port Number for peer connections (default <port>+1000)")
+	network := flag.String("network", blockchain.DEFAULT_NETWORK, "Network id; peers on another network are refused")
+	seeds := flag.String("seeds", "", "Comma separated host:port addresses of the peers to start from")
+	minerThreads := flag.Uint("miner-threads", 0, "Goroutines searching for proof-of-work (default one per CPU)")
+	mine := flag.Bool("mine", false, "Start mining as soon as the node is up")
+	minerAddress := flag.String("miner-address", "", "Blockchain address mining rewards are paid to")
 	flag.Parse()
 
-	app := NewBlockchainNode(uint16(*port))
+	if *dataDir == "" {
+		*dataDir = filepath.Join("data", strconv.Itoa(int(*port)))
+	}
+	if *p2pPort == 0 {
+		*p2pPort = *port + 1000
+	}
+	if *advertise == "" {
+		*advertise = fmt.Sprintf("127.0.0.1:%d", *port)
+	}
+
+	if *minerAddress != "" && !utils.ValidAddress(*minerAddress) {
+		log.Fatalf("ERROR: invalid miner address %q", *minerAddress)
+	}
+	if *mine && *minerAddress == "" {
+		log.Fatal("ERROR: -mine requires -miner-address")
+	}
+
+	var seedPeers []string
+	for _, seed := range strings.Split(*seeds, ",") {
+		if seed = strings.TrimSpace(seed); seed != "" {
+			seedPeers = append(seedPeers, seed)
+		}
+	}
+
+	app := NewBlockchainNode(uint16(*port), *dataDir, *advertise, uint16(*p2pPort), *network, seedPeers, int(*minerThreads), *mine, *minerAddress)
 	log.Default().Println("Starting blockchain node on port:", *port)
 	app.Run()
 }
@@ -3,6 +3,6 @@ module github.com/jvsena42/go_blockchain
 go 1.22.2
 
 require (
-	github.com/btcsuite/btcutil v1.0.2 // indirect
-	golang.org/x/crypto v0.22.0 // indirect
+	github.com/btcsuite/btcutil v1.0.2
+	golang.org/x/crypto v0.22.0
 )
@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+
+	"github.com/btcsuite/btcutil/base58"
+	"golang.org/x/crypto/ripemd160"
+)
+
+// PublicKeyToAddress derives the blockchain address owned by publicKey.
+func PublicKeyToAddress(publicKey *ecdsa.PublicKey) string {
+	// 1. Carry out SHA-256 hashing on the public key (32 bytes).
+	h1 := sha256.New()
+	h1.Write(publicKey.X.Bytes())
+	h1.Write(publicKey.Y.Bytes())
+	digest1 := h1.Sum(nil)
+	// 2. Carry out RIPEMD-160 hashing on the result of the SHA-256 (20 bytes).
+	h2 := ripemd160.New()
+	h2.Write(digest1)
+	digest2 := h2.Sum(nil)
+	// 3. Add a version byte in front of RIPEMD-160 hash (0x00 for Main Network).
+	vd3 := make([]byte, 21)
+	vd3[0] = 0x00
+	copy(vd3[1:], digest2[:])
+	// 4. Take the first 4 bytes of a double SHA-256 hash as checksum.
+	chksum := addressChecksum(vd3)
+	// 5. Add the 4 checksum bytes at the end of extended RIPEMD-160 hash from 3. (25 bytes).
+	dc5 := make([]byte, 25)
+	copy(dc5[:21], vd3[:])
+	copy(dc5[21:], chksum[:])
+	// 6. Convert the result from the byte string into base58.
+	return base58.Encode(dc5)
+}
+
+func addressChecksum(versioned []byte) []byte {
+	digest := sha256.Sum256(versioned)
+	digest = sha256.Sum256(digest[:])
+	return digest[:4]
+}
+
+// ValidAddress reports whether address is a base58check encoded blockchain
+// address with the main network version byte and a matching checksum.
+func ValidAddress(address string) bool {
+	decoded := base58.Decode(address)
+	if len(decoded) != 25 || decoded[0] != 0x00 {
+		return false
+	}
+	return bytes.Equal(addressChecksum(decoded[:21]), decoded[21:])
+}
@@ -17,6 +17,20 @@ func (s *Signature) String() string {
 	return fmt.Sprintf("%064x%064x", s.R, s.S)
 }
 
+func PublicKeyToString(publicKey *ecdsa.PublicKey) string {
+	return fmt.Sprintf("%064x%064x", publicKey.X.Bytes(), publicKey.Y.Bytes())
+}
+
+// ValidHexString reports whether s is exactly length hexadecimal characters,
+// as expected by StringToPublicKey and StringToSignature.
+func ValidHexString(s string, length int) bool {
+	if len(s) != length {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
 func StringToBigIntTuples(s string) (big.Int, big.Int) {
 	bx, _ := hex.DecodeString(s[:64])
 	by, _ := hex.DecodeString(s[64:])
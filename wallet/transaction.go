@@ -3,9 +3,10 @@ package wallet
 import (
 	"crypto/ecdsa"
 	"crypto/rand"
-	"crypto/sha256"
-	"encoding/json"
+	"fmt"
+	"strings"
 
+	"github.com/jvsena42/go_blockchain/blockchain"
 	"github.com/jvsena42/go_blockchain/utils"
 )
 
@@ -14,15 +15,19 @@ type Transaction struct {
 	senderPublicKey  *ecdsa.PublicKey
 	senderAddress    string
 	recipientAddress string
-	value            float32
+	value            blockchain.Amount
+	utxos            []*blockchain.UTXO
 }
 
+// NewTransaction prepares a payment of value from sender to recipient funded
+// by utxos, the sender's spendable outputs.
 func NewTransaction(
 	privateKey *ecdsa.PrivateKey,
 	publicKey *ecdsa.PublicKey,
 	sender string,
 	recipient string,
-	value float32,
+	value blockchain.Amount,
+	utxos []*blockchain.UTXO,
 ) *Transaction {
 	return &Transaction{
 		senderPrivateKey: privateKey,
@@ -30,26 +35,66 @@ func NewTransaction(
 		senderAddress:    sender,
 		recipientAddress: recipient,
 		value:            value,
+		utxos:            utxos,
 	}
 }
 
-func (t *Transaction) MarshalJson() ([]byte, error) {
-	return json.Marshal(struct {
-		SenderAddress    string  `json:"sender_blockchain_address"`
-		RecipientAddress string  `json:"recipient_blockchain_address"`
-		Value            float32 `json:"value"`
-	}{
-		SenderAddress:    t.senderAddress,
-		RecipientAddress: t.recipientAddress,
-		Value:            t.value,
-	})
+// GenerateTransaction spends the sender's outputs in order until they cover
+// the value and the minimum relay fee, returns what is left over to the
+// sender as change and signs every input.
+func (t *Transaction) GenerateTransaction() (*blockchain.Transaction, error) {
+	// The fee depends on the size of the transaction, which depends on how
+	// many inputs the fee makes it spend, so the two are settled together.
+	// Signatures have a fixed length, so placeholders give the final size.
+	var fee blockchain.Amount
+	var transaction *blockchain.Transaction
+	for {
+		var err error
+		transaction, err = t.assemble(fee)
+		if err != nil {
+			return nil, err
+		}
+		minimum := transaction.MinimumFee()
+		if fee >= minimum {
+			break
+		}
+		fee = minimum
+	}
+
+	h := transaction.Hash()
+	for _, in := range transaction.Inputs {
+		r, s, err := ecdsa.Sign(rand.Reader, t.senderPrivateKey, h[:])
+		if err != nil {
+			return nil, err
+		}
+		in.Signature = (&utils.Signature{R: r, S: s}).String()
+	}
+	return transaction, nil
 }
 
-func (t *Transaction) GenerateSignature() *utils.Signature {
-	m, _ := t.MarshalJson()
-	h := sha256.Sum256([]byte(m))
-	r, s, _ := ecdsa.Sign(rand.Reader, t.senderPrivateKey, h[:])
-	return &utils.Signature{R: r, S: s}
+// assemble builds the unsigned transaction paying value and fee.
+func (t *Transaction) assemble(fee blockchain.Amount) (*blockchain.Transaction, error) {
+	publicKey := utils.PublicKeyToString(t.senderPublicKey)
+	placeholder := strings.Repeat("0", 128)
+
+	var inputs []*blockchain.TxInput
+	var total blockchain.Amount
+	for _, u := range t.utxos {
+		if total >= t.value+fee {
+			break
+		}
+		inputs = append(inputs, &blockchain.TxInput{PreviousOutput: u.OutPoint, PublicKey: publicKey, Signature: placeholder})
+		total += u.Output.Value
+	}
+	if total < t.value+fee {
+		return nil, fmt.Errorf("%w: %s available, %s requested with a fee of %s", blockchain.ErrInsufficientBalance, total, t.value, fee)
+	}
+
+	outputs := []*blockchain.TxOutput{{Address: t.recipientAddress, Value: t.value}}
+	if change := total - t.value - fee; change > 0 {
+		outputs = append(outputs, &blockchain.TxOutput{Address: t.senderAddress, Value: change})
+	}
+	return blockchain.NewTransaction(inputs, outputs, fee), nil
 }
 
 type TransactionRequest struct {
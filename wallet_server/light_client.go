@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jvsena42/go_blockchain/blockchain"
+)
+
+const (
+	LIGHT_CLIENT_SYNC_TIME_SEC = 10
+	// A gateway answering from further behind the validated tip than this is
+	// reported as disagreeing with the header chain.
+	LIGHT_CLIENT_MAX_LAG = 2
+	// A node that has not answered a header request by then is given up on
+	// until the next round.
+	LIGHT_CLIENT_TIMEOUT = 10 * time.Second
+)
+
+// LightClient follows the chain through block headers only. It validates the
+// linkage and proof-of-work of every header its nodes serve, keeps the
+// branch with the most work and checks gateway answers against it.
+type LightClient struct {
+	nodes   []string
+	headers []*blockchain.BlockHeader
+	byHash  map[[32]byte]*blockchain.BlockHeader
+	mux     sync.Mutex
+}
+
+func NewLightClient(nodes []string) *LightClient {
+	genesis := blockchain.GenesisBlock().BlockHeader
+	return &LightClient{
+		nodes:   nodes,
+		headers: []*blockchain.BlockHeader{&genesis},
+		byHash:  map[[32]byte]*blockchain.BlockHeader{genesis.Hash(): &genesis},
+	}
+}
+
+func (lc *LightClient) StartSync() {
+	lc.Sync()
+	_ = time.AfterFunc(time.Second*LIGHT_CLIENT_SYNC_TIME_SEC, lc.StartSync)
+}
+
+func (lc *LightClient) Sync() {
+	for _, node := range lc.nodes {
+		if err := lc.syncFrom(node); err != nil {
+			log.Printf("Light client: could not sync headers from %s: %v", node, err)
+		}
+	}
+}
+
+// syncFrom downloads the headers node has past our tip. If they do not
+// connect to our tip the node follows another branch, which is then fetched
+// from the genesis block and adopted only if it carries more work.
+func (lc *LightClient) syncFrom(node string) error {
+	lc.mux.Lock()
+	current := lc.headers
+	lc.mux.Unlock()
+
+	from := len(current)
+	page, err := fetchHeaders(node, from)
+	if err != nil {
+		return err
+	}
+	if len(page) > 0 && page[0].PreviousHash != current[from-1].Hash() {
+		from = 1
+		if page, err = fetchHeaders(node, from); err != nil {
+			return err
+		}
+	}
+
+	candidate := append([]*blockchain.BlockHeader{}, current[:from]...)
+	for len(page) > 0 {
+		for _, header := range page {
+			previous := candidate[len(candidate)-1]
+			if err := blockchain.CheckHeader(header, previous, blockchain.NextHeaderBits(candidate)); err != nil {
+				return err
+			}
+			candidate = append(candidate, header)
+		}
+		if len(page) < blockchain.MAX_HEADERS_PER_REQUEST {
+			break
+		}
+		if page, err = fetchHeaders(node, len(candidate)); err != nil {
+			return err
+		}
+	}
+
+	lc.mux.Lock()
+	defer lc.mux.Unlock()
+
+	if headersWork(candidate).Cmp(headersWork(lc.headers)) <= 0 {
+		return nil
+	}
+	lc.headers = candidate
+	lc.byHash = make(map[[32]byte]*blockchain.BlockHeader, len(candidate))
+	for _, header := range candidate {
+		lc.byHash[header.Hash()] = header
+	}
+	log.Printf("Light client: validated headers up to height %d from %s", candidate[len(candidate)-1].Height, node)
+	return nil
+}
+
+// fetchHeaders gives up on a stalled node after LIGHT_CLIENT_TIMEOUT, so the
+// sync loop is never held up for good.
+func fetchHeaders(node string, from int) ([]*blockchain.BlockHeader, error) {
+	client := &http.Client{Timeout: LIGHT_CLIENT_TIMEOUT}
+	resp, err := client.Get(fmt.Sprintf("%s/headers?from=%d", node, from))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("node answered %s", resp.Status)
+	}
+
+	var headersResp blockchain.HeadersResponse
+	if err := json.NewDecoder(resp.Body).Decode(&headersResp); err != nil {
+		return nil, err
+	}
+	for _, header := range headersResp.Headers {
+		if header == nil {
+			return nil, errors.New("node sent a null header")
+		}
+	}
+	return headersResp.Headers, nil
+}
+
+func headersWork(headers []*blockchain.BlockHeader) *big.Int {
+	work := new(big.Int)
+	for _, header := range headers {
+		work.Add(work, header.Work())
+	}
+	return work
+}
+
+// Height is the height of the validated tip.
+func (lc *LightClient) Height() uint64 {
+	lc.mux.Lock()
+	defer lc.mux.Unlock()
+	return lc.headers[len(lc.headers)-1].Height
+}
+
+// CheckTip reports an error when a gateway answered from a block that is not
+// part of the validated header chain, or from too far behind its tip.
+func (lc *LightClient) CheckTip(height uint64, hash [32]byte) error {
+	lc.mux.Lock()
+	defer lc.mux.Unlock()
+
+	header, ok := lc.byHash[hash]
+	if !ok || header.Height != height {
+		return fmt.Errorf("gateway block %x at height %d is not in the validated header chain", hash, height)
+	}
+
+	tip := lc.headers[len(lc.headers)-1].Height
+	if height+LIGHT_CLIENT_MAX_LAG < tip {
+		return fmt.Errorf("gateway is at height %d, %d blocks behind the validated tip", height, tip-height)
+	}
+	return nil
+}
+
+// VerifyProof checks an inclusion proof against the validated headers and
+// returns how many blocks confirm the transaction.
+func (lc *LightClient) VerifyProof(proof *blockchain.InclusionProof) (uint64, error) {
+	lc.mux.Lock()
+	defer lc.mux.Unlock()
+
+	if err := proof.Verify(lc.byHash); err != nil {
+		return 0, err
+	}
+	tip := lc.headers[len(lc.headers)-1].Height
+	return tip - proof.Header.Height + 1, nil
+}
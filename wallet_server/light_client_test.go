@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/jvsena42/go_blockchain/blockchain"
+	"github.com/jvsena42/go_blockchain/wallet"
+)
+
+// minedHeaders mines height blocks on a fresh chain and returns its headers,
+// the genesis block first.
+func minedHeaders(t *testing.T, height int) []*blockchain.BlockHeader {
+	t.Helper()
+	bc, err := blockchain.NewBlockchain(0, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	miner, address := blockchain.NewMiner(bc, 1), wallet.NewWallet().BlockchainAddress()
+	for i := 0; i < height; i++ {
+		if miner.MineBlock(address, nil) == nil {
+			t.Fatal("block not mined")
+		}
+	}
+	return bc.Headers(0)
+}
+
+// fakeNode serves whatever headers serve returns for the requested height.
+func fakeNode(t *testing.T, serve func(from int) []*blockchain.BlockHeader) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		from, _ := strconv.Atoi(r.URL.Query().Get("from"))
+		json.NewEncoder(w).Encode(&blockchain.HeadersResponse{Headers: serve(from)})
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func honestNode(t *testing.T, headers []*blockchain.BlockHeader) string {
+	return fakeNode(t, func(from int) []*blockchain.BlockHeader {
+		return headers[min(from, len(headers)):]
+	})
+}
+
+func TestLightClientFollowsHeaders(t *testing.T) {
+	headers := minedHeaders(t, 3)
+	lc := NewLightClient(nil)
+
+	if err := lc.syncFrom(honestNode(t, headers)); err != nil {
+		t.Fatal(err)
+	}
+	if got := lc.Height(); got != 3 {
+		t.Fatalf("validated tip at height %d, want 3", got)
+	}
+	if err := lc.CheckTip(3, headers[3].Hash()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLightClientRejectsBadHeaders(t *testing.T) {
+	headers := minedHeaders(t, 3)
+
+	nodes := map[string]func(from int) []*blockchain.BlockHeader{
+		"null": func(int) []*blockchain.BlockHeader {
+			return []*blockchain.BlockHeader{nil}
+		},
+		"out of order": func(int) []*blockchain.BlockHeader {
+			return []*blockchain.BlockHeader{headers[1], headers[3], headers[2]}
+		},
+	}
+	for name, serve := range nodes {
+		lc := NewLightClient(nil)
+		if err := lc.syncFrom(fakeNode(t, serve)); err == nil {
+			t.Errorf("%s: headers accepted", name)
+		}
+		if got := lc.Height(); got != 0 {
+			t.Errorf("%s: validated tip moved to height %d", name, got)
+		}
+	}
+}
+
+func TestLightClientKeepsMostWork(t *testing.T) {
+	headers := minedHeaders(t, 3)
+	lc := NewLightClient(nil)
+	if err := lc.syncFrom(honestNode(t, headers)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A node on a shorter branch answers with it whatever height is asked.
+	branch := minedHeaders(t, 1)
+	lowWork := fakeNode(t, func(int) []*blockchain.BlockHeader { return branch[1:] })
+	if err := lc.syncFrom(lowWork); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := lc.CheckTip(3, headers[3].Hash()); err != nil {
+		t.Errorf("branch with less work adopted: %v", err)
+	}
+	if err := lc.CheckTip(1, branch[1].Hash()); err == nil {
+		t.Error("header of the branch with less work validated")
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 	"log"
+	"strings"
 )
 
 func Init() {
@@ -12,9 +13,21 @@ func Init() {
 func main() {
 	port := flag.Uint("port", 8080, "TCP Port number for online wallet")
 	gateway := flag.String("gateway", "http://127.0.0.1:3333", "TCP Port number for online wallet")
+	light := flag.Bool("light", false, "Validate block headers and check the gateway's answers against them")
+	nodes := flag.String("nodes", "", "Comma separated blockchain nodes to sync headers from in light mode (default the gateway)")
 	flag.Parse()
 
-	app := NewWalletServer(uint16(*port), *gateway)
+	var lightClient *LightClient
+	if *light {
+		headerNodes := []string{*gateway}
+		if *nodes != "" {
+			headerNodes = strings.Split(*nodes, ",")
+		}
+		lightClient = NewLightClient(headerNodes)
+		log.Println("Light client mode, syncing headers from", headerNodes)
+	}
+
+	app := NewWalletServer(uint16(*port), *gateway, lightClient)
 	log.Println("Starting server on port:", *port, "Using blockchain node", *gateway, " as gateway")
 	app.Run()
 }
@@ -2,14 +2,17 @@ package main
 
 import (
 	"bytes"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"html/template"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"path"
 	"strconv"
+	"strings"
 
 	"github.com/jvsena42/go_blockchain/blockchain"
 	"github.com/jvsena42/go_blockchain/utils"
@@ -19,12 +22,16 @@ import (
 const pathToTemplateDir = "templates"
 
 type WalletServer struct {
-	port    uint16
-	gateway string
+	port        uint16
+	gateway     string
+	lightClient *LightClient
 }
 
-func NewWalletServer(port uint16, gateway string) *WalletServer {
-	return &WalletServer{port: port, gateway: gateway}
+// NewWalletServer creates a wallet server using gateway for balances and
+// transactions. When lightClient is not nil its validated header chain is
+// used to check the gateway's answers.
+func NewWalletServer(port uint16, gateway string, lightClient *LightClient) *WalletServer {
+	return &WalletServer{port: port, gateway: gateway, lightClient: lightClient}
 }
 
 func (ws *WalletServer) Port() uint16 {
@@ -77,30 +84,42 @@ func (ws *WalletServer) CreateTransaction(w http.ResponseWriter, r *http.Request
 			return
 		}
 
+		if !utils.ValidAddress(*t.RecipientBlockchainAddress) {
+			log.Println("ERROR: invalid recipient address", *t.RecipientBlockchainAddress)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+
 		publicKey := utils.StringToPublicKey(*t.SenderPublicKey)
 		privateKey := utils.StringToPrivateKey(*t.SenderPrivateKey, publicKey)
-		value, err := strconv.ParseFloat(*t.Value, 32)
+		value, err := blockchain.ParseAmount(*t.Value)
 
 		if err != nil {
-			log.Println("ERROR: parsing value")
+			log.Println("ERROR: parsing value", err)
 			io.WriteString(w, string(utils.JsonStatus("Error: invalid value")))
 			return
 		}
 
-		value32 := float32(value)
+		utxos, err := ws.spendableOutputs(*t.SenderBlockchainAddress)
+		if err != nil {
+			log.Printf("/Trancasctions ERROR: fetching outputs %v", err)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
 
 		w.Header().Add("Content-Type", "application/json")
 
-		transaction := wallet.NewTransaction(privateKey, publicKey, *t.SenderBlockchainAddress, *t.RecipientBlockchainAddress, value32)
-		signature := transaction.GenerateSignature()
-		signatureStr := signature.String()
+		transaction, err := wallet.NewTransaction(privateKey, publicKey, *t.SenderBlockchainAddress, *t.RecipientBlockchainAddress, value, utxos).GenerateTransaction()
+		if err != nil {
+			log.Printf("/Trancasctions ERROR: %v", err)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
 
 		bt := &blockchain.TransactionRequest{
-			SenderBlockchainAddress:    t.SenderBlockchainAddress,
-			RecipientBlockchainAddress: t.RecipientBlockchainAddress,
-			SenderPublicKey:            t.SenderPublicKey,
-			Value:                      &value32,
-			Signature:                  &signatureStr,
+			Inputs:  transaction.Inputs,
+			Outputs: transaction.Outputs,
+			Fee:     &transaction.Fee,
 		}
 
 		m, err := json.Marshal(bt)
@@ -121,10 +140,21 @@ func (ws *WalletServer) CreateTransaction(w http.ResponseWriter, r *http.Request
 		}
 
 		if resp.StatusCode == 201 {
-			io.WriteString(w, string(utils.JsonStatus("success")))
+			m, _ := json.Marshal(struct {
+				Message       string `json:"message"`
+				TransactionId string `json:"transaction_id"`
+				Fee           string `json:"fee"`
+			}{
+				Message:       "success",
+				TransactionId: fmt.Sprintf("%x", transaction.Hash()),
+				Fee:           transaction.Fee.String(),
+			})
+			io.WriteString(w, string(m[:]))
 			return
 		} else {
-			io.WriteString(w, string(utils.JsonStatus("error")))
+			body, _ := io.ReadAll(resp.Body)
+			log.Printf("/Trancasctions ERROR: rejected by gateway %s", body)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
 		}
 
 	default:
@@ -133,6 +163,33 @@ func (ws *WalletServer) CreateTransaction(w http.ResponseWriter, r *http.Request
 	}
 }
 
+// spendableOutputs asks the gateway for the outputs blockchainAddress can
+// fund its next transaction with.
+func (ws *WalletServer) spendableOutputs(blockchainAddress string) ([]*blockchain.UTXO, error) {
+	endpoint := fmt.Sprintf("%s/utxos", ws.Gateway())
+
+	bcnRequest, _ := http.NewRequest("GET", endpoint, nil)
+	query := bcnRequest.URL.Query()
+	query.Add("blockchain_address", blockchainAddress)
+	bcnRequest.URL.RawQuery = query.Encode()
+
+	bcnResponse, err := http.DefaultClient.Do(bcnRequest)
+	if err != nil {
+		return nil, err
+	}
+	defer bcnResponse.Body.Close()
+
+	if bcnResponse.StatusCode != 200 {
+		return nil, fmt.Errorf("gateway answered %s", bcnResponse.Status)
+	}
+
+	var utxoResp blockchain.UTXOResponse
+	if err := json.NewDecoder(bcnResponse.Body).Decode(&utxoResp); err != nil {
+		return nil, err
+	}
+	return utxoResp.UTXOs, nil
+}
+
 func (ws *WalletServer) WalletAmount(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
@@ -163,12 +220,26 @@ func (ws *WalletServer) WalletAmount(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 
+			verified, warning := false, ""
+			if ws.lightClient != nil {
+				if err := ws.lightClient.CheckTip(barResp.Height, barResp.BlockHash); err != nil {
+					log.Printf("/wallet/amount WARNING: %v", err)
+					warning = err.Error()
+				} else {
+					verified = true
+				}
+			}
+
 			m, _ := json.Marshal(struct {
-				Message string  `json:"message"`
-				Amount  float32 `json:"amount"`
+				Message  string `json:"message"`
+				Amount   string `json:"amount"`
+				Verified bool   `json:"verified"`
+				Warning  string `json:"warning,omitempty"`
 			}{
-				Message: "success",
-				Amount:  barResp.Amount,
+				Message:  "success",
+				Amount:   barResp.Amount.String(),
+				Verified: verified,
+				Warning:  warning,
 			})
 
 			io.WriteString(w, string(m[:]))
@@ -182,10 +253,87 @@ func (ws *WalletServer) WalletAmount(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// TransactionStatus asks the gateway for an inclusion proof of a transaction
+// and, in light client mode, checks it against the validated headers.
+func (ws *WalletServer) TransactionStatus(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Add("Content-Type", "application/json")
+		id := r.URL.Query().Get("id")
+		endpoint := fmt.Sprintf("%s/tx/%s/proof", ws.Gateway(), url.PathEscape(id))
+
+		bcnResponse, err := http.Get(endpoint)
+		if err != nil {
+			log.Printf("/wallet/transaction ERROR: %v", err)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		defer bcnResponse.Body.Close()
+
+		var status struct {
+			Message       string `json:"message"`
+			Confirmed     bool   `json:"confirmed"`
+			Verified      bool   `json:"verified"`
+			Confirmations uint64 `json:"confirmations,omitempty"`
+			Warning       string `json:"warning,omitempty"`
+		}
+		status.Message = "success"
+
+		switch bcnResponse.StatusCode {
+		case http.StatusOK:
+			var proof blockchain.InclusionProof
+			if err := json.NewDecoder(bcnResponse.Body).Decode(&proof); err != nil {
+				log.Printf("/wallet/transaction ERROR: %v", err)
+				io.WriteString(w, string(utils.JsonStatus("fail")))
+				return
+			}
+			// A valid proof of another transaction would otherwise pass for
+			// a proof of this one.
+			if hex.EncodeToString(proof.TransactionHash[:]) != strings.ToLower(id) {
+				log.Printf("/wallet/transaction ERROR: gateway answered for %s with a proof of %x", id, proof.TransactionHash)
+				io.WriteString(w, string(utils.JsonStatus("fail")))
+				return
+			}
+
+			status.Confirmed = true
+			if ws.lightClient != nil {
+				confirmations, err := ws.lightClient.VerifyProof(&proof)
+				if err != nil {
+					log.Printf("/wallet/transaction WARNING: gateway proof for %s rejected: %v", id, err)
+					status.Confirmed = false
+					status.Warning = err.Error()
+				} else {
+					status.Verified = true
+					status.Confirmations = confirmations
+				}
+			}
+
+		case http.StatusNotFound:
+			status.Confirmed = false
+
+		default:
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+
+		m, _ := json.Marshal(status)
+		io.WriteString(w, string(m[:]))
+
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("/wallet/transaction ERROR: Invalid HTTP method", r.Method)
+	}
+}
+
 func (ws *WalletServer) Run() {
+	if ws.lightClient != nil {
+		ws.lightClient.StartSync()
+	}
+
 	http.HandleFunc("/", ws.Index)
 	http.HandleFunc("/wallet", ws.Wallet)
 	http.HandleFunc("/wallet/amount", ws.WalletAmount)
+	http.HandleFunc("/wallet/transaction", ws.TransactionStatus)
 	http.HandleFunc("/transactions", ws.CreateTransaction)
 
 	log.Fatal(http.ListenAndServe("0.0.0.0:"+strconv.Itoa(int(ws.port)), nil))